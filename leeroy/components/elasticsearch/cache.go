@@ -3,15 +3,26 @@ package elasticsearch
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/conflicts"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/optype"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+	"github.com/shono-io/leeroy/leeroy/components/elasticsearch/connection"
 	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
 	"time"
 )
 
+// cacheValueField is the field of the envelope of an expiring entry holding its value. The field marks a document as an
+// envelope, so it is reserved within the values of entries living forever.
+const cacheValueField = "_cache_value"
+
 func init() {
 	err := service.RegisterCache("elasticsearch", cacheConfig(), newCache)
 	if err != nil {
@@ -22,30 +33,94 @@ func init() {
 func cacheConfig() *service.ConfigSpec {
 	return service.NewConfigSpec().
 		Field(service.NewStringField("index").Description("The Elasticsearch index to use for storing cache entries.")).
-		Fields(clientFields()...)
+		Field(connection.RefreshField()).
+		Field(service.NewDurationField("default_ttl").
+			Description("How long entries live when they are written without a ttl. Entries written without a ttl are kept forever when not set.").
+			Optional()).
+		Field(service.NewStringField("ttl_field").
+			Description("The field of the cached documents holding the time an entry expires at. Expiring entries are stored as a document holding the base64 encoded value in `_cache_value` along with this field, while entries living forever are stored as they are and must not hold a `_cache_value` field. Expired entries are treated as missing until they are purged.").
+			Default("_expires_at").
+			Advanced()).
+		Field(service.NewDurationField("purge_interval").
+			Description("How often expired entries are deleted from the index. Set to `0s` to disable purging.").
+			Default("1m").
+			Advanced()).
+		Fields(connection.Fields()...)
 }
 
 func newCache(conf *service.ParsedConfig, mgr *service.Resources) (service.Cache, error) {
-	cl, err := newClient(conf)
+	idx, err := conf.FieldString("index")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+
+	rp, err := connection.FieldRefresh(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	idx, err := conf.FieldString("index")
+	var defaultTTL *time.Duration
+	if conf.Contains("default_ttl") {
+		ttl, err := conf.FieldDuration("default_ttl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse default_ttl: %w", err)
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("default_ttl must be positive")
+		}
+		defaultTTL = &ttl
+	}
+
+	ttlField, err := conf.FieldString("ttl_field")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse index: %w", err)
+		return nil, fmt.Errorf("failed to parse ttl_field: %w", err)
+	}
+	if ttlField == "" {
+		return nil, fmt.Errorf("ttl_field must not be empty")
+	}
+
+	purgeInterval, err := conf.FieldDuration("purge_interval")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse purge_interval: %w", err)
+	}
+
+	conn, err := connection.FromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cache{
+		conn:       conn,
+		cl:         conn.Client(),
+		index:      idx,
+		refresh:    rp,
+		defaultTTL: defaultTTL,
+		ttlField:   ttlField,
+		logger:     mgr.Logger(),
 	}
 
-	return &cache{cl: cl, index: idx, logger: mgr.Logger()}, nil
+	if purgeInterval > 0 {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.purgeEvery(purgeInterval)
+	}
+
+	return c, nil
 }
 
 type cache struct {
-	cl     *elasticsearch.TypedClient
-	index  string
-	logger *service.Logger
+	conn       *connection.Connection
+	cl         *elasticsearch.TypedClient
+	index      string
+	refresh    refresh.Refresh
+	defaultTTL *time.Duration
+	ttlField   string
+	logger     *service.Logger
+
+	stop chan struct{}
+	done chan struct{}
 }
 
-func (c cache) Get(ctx context.Context, key string) ([]byte, error) {
+func (c *cache) Get(ctx context.Context, key string) ([]byte, error) {
 	res, err := c.cl.Get(c.index, key).Do(ctx)
 	if err != nil {
 		return nil, err
@@ -54,11 +129,24 @@ func (c cache) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, service.ErrKeyNotFound
 	}
 
-	return res.Source_, nil
+	value, expired, err := c.unwrap(res.Source_, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, service.ErrKeyNotFound
+	}
+
+	return value, nil
 }
 
-func (c cache) Set(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
-	_, err := c.cl.Index(c.index).Id(key).Raw(bytes.NewBuffer(value)).Refresh(refresh.True).Do(ctx)
+func (c *cache) Set(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	doc, err := c.wrap(value, ttl, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.cl.Index(c.index).Id(key).Raw(bytes.NewBuffer(doc)).Refresh(c.refresh).Do(ctx)
 	if err != nil {
 		return err
 	}
@@ -66,13 +154,23 @@ func (c cache) Set(ctx context.Context, key string, value []byte, ttl *time.Dura
 	return nil
 }
 
-func (c cache) Add(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
-	_, err := c.cl.Index(c.index).
+func (c *cache) Add(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	now := time.Now()
+
+	doc, err := c.wrap(value, ttl, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.cl.Index(c.index).
 		Id(key).
-		Raw(bytes.NewBuffer(value)).
-		Refresh(refresh.True).
+		Raw(bytes.NewBuffer(doc)).
+		Refresh(c.refresh).
 		OpType(optype.Create).
 		Do(ctx)
+	if isConflict(err) {
+		return c.replaceExpired(ctx, key, doc, now)
+	}
 	if err != nil {
 		return err
 	}
@@ -80,8 +178,35 @@ func (c cache) Add(ctx context.Context, key string, value []byte, ttl *time.Dura
 	return nil
 }
 
-func (c cache) Delete(ctx context.Context, key string) error {
-	_, err := c.cl.Delete(c.index, key).Refresh(refresh.True).Do(ctx)
+// replaceExpired replaces the existing entry with the document if the entry has expired, as if it did not exist. The
+// entry is only replaced if it was not changed in the meantime.
+func (c *cache) replaceExpired(ctx context.Context, key string, doc []byte, now time.Time) error {
+	res, err := c.cl.Get(c.index, key).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.Found || res.SeqNo_ == nil || res.PrimaryTerm_ == nil {
+		return service.ErrKeyAlreadyExists
+	}
+
+	_, expired, err := c.unwrap(res.Source_, now)
+	if err != nil {
+		return err
+	}
+	if !expired {
+		return service.ErrKeyAlreadyExists
+	}
+
+	_, err = c.cl.Index(c.index).
+		Id(key).
+		Raw(bytes.NewBuffer(doc)).
+		Refresh(c.refresh).
+		IfSeqNo(strconv.FormatInt(*res.SeqNo_, 10)).
+		IfPrimaryTerm(strconv.FormatInt(*res.PrimaryTerm_, 10)).
+		Do(ctx)
+	if isConflict(err) {
+		return service.ErrKeyAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
@@ -89,6 +214,139 @@ func (c cache) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
-func (c cache) Close(ctx context.Context) error {
+func (c *cache) Delete(ctx context.Context, key string) error {
+	_, err := c.cl.Delete(c.index, key).Refresh(c.refresh).Do(ctx)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// wrap puts the value of an expiring entry in an envelope along with the time the entry expires at. The value is held
+// base64 encoded, so any value can expire. Entries living forever are stored untouched.
+func (c *cache) wrap(value []byte, ttl *time.Duration, now time.Time) ([]byte, error) {
+	if ttl == nil {
+		ttl = c.defaultTTL
+	}
+	if ttl == nil {
+		return value, nil
+	}
+
+	return json.Marshal(map[string]any{
+		cacheValueField: value,
+		c.ttlField:      now.Add(*ttl).UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// unwrap takes the value out of the envelope of an expiring entry, telling whether the entry has expired.
+func (c *cache) unwrap(doc []byte, now time.Time) ([]byte, bool, error) {
+	// -- entries living forever are returned untouched
+	if !bytes.Contains(doc, []byte(strconv.Quote(cacheValueField))) {
+		return doc, false, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, false, err
+	}
+
+	if _, fnd := fields[cacheValueField]; !fnd {
+		return doc, false, nil
+	}
+
+	raw, fnd := fields[c.ttlField]
+	if !fnd {
+		return nil, false, fmt.Errorf("cache entry holds no expiry time")
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return nil, false, fmt.Errorf("invalid expiry time of cache entry: %w", err)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid expiry time of cache entry: %w", err)
+	}
+	if !expiresAt.After(now) {
+		return nil, true, nil
+	}
+
+	var value []byte
+	if err := json.Unmarshal(fields[cacheValueField], &value); err != nil {
+		return nil, false, fmt.Errorf("invalid value of cache entry: %w", err)
+	}
+
+	return value, false, nil
+}
+
+func (c *cache) purgeEvery(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			cnt, err := c.purge(ctx, now)
+			cancel()
+
+			if err != nil {
+				c.logger.Warnf("failed to purge expired cache entries from %s: %v", c.index, err)
+			} else if cnt > 0 {
+				c.logger.Debugf("purged %d expired cache entries from %s", cnt, c.index)
+			}
+		}
+	}
+}
+
+// purge deletes the entries which expired at or before now, returning the number of deleted entries.
+func (c *cache) purge(ctx context.Context, now time.Time) (int64, error) {
+	res, err := c.cl.DeleteByQuery(c.index).Query(c.purgeQuery(now)).Conflicts(conflicts.Proceed).Do(ctx)
+	if err != nil {
+		// -- nothing expires from an index which does not exist yet
+		var esErr *types.ElasticsearchError
+		if errors.As(err, &esErr) && esErr.Status == http.StatusNotFound {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	if res.Deleted == nil {
+		return 0, nil
+	}
+
+	return *res.Deleted, nil
+}
+
+// purgeQuery selects the envelopes of the entries which expired at or before now, leaving entries living forever alone
+// even when their value holds the ttl field.
+func (c *cache) purgeQuery(now time.Time) *types.Query {
+	return &types.Query{Bool: &types.BoolQuery{Filter: []types.Query{
+		{Exists: &types.ExistsQuery{Field: cacheValueField}},
+		{Range: map[string]types.RangeQuery{
+			c.ttlField: map[string]any{"lte": now.UTC().Format(time.RFC3339Nano)},
+		}},
+	}}}
+}
+
+func isConflict(err error) bool {
+	var esErr *types.ElasticsearchError
+	return errors.As(err, &esErr) && esErr.Status == http.StatusConflict
+}
+
+func (c *cache) Close(ctx context.Context) error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+
+	return c.conn.Close()
+}
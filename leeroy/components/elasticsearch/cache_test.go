@@ -0,0 +1,90 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_Cache__shouldKeepEntriesWithoutTtl(t *testing.T) {
+	c := &cache{ttlField: "_expires_at"}
+	now := time.Now()
+
+	doc, err := c.wrap([]byte(`{"count": 1}`), nil, now)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"count": 1}`, string(doc))
+
+	value, expired, err := c.unwrap(doc, now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, expired)
+	assert.JSONEq(t, `{"count": 1}`, string(value))
+}
+
+func Test_Cache__shouldExpireEntries(t *testing.T) {
+	ttl := time.Minute
+	c := &cache{ttlField: "_expires_at", defaultTTL: &ttl}
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	doc, err := c.wrap([]byte(`{"count": 1}`), nil, now)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"_cache_value": "eyJjb3VudCI6IDF9", "_expires_at": "2023-06-01T12:01:00Z"}`, string(doc))
+
+	value, expired, err := c.unwrap(doc, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.False(t, expired)
+	assert.JSONEq(t, `{"count": 1}`, string(value))
+
+	_, expired, err = c.unwrap(doc, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, expired)
+}
+
+func Test_Cache__shouldPreferGivenTtl(t *testing.T) {
+	ttl := time.Hour
+	c := &cache{ttlField: "_expires_at", defaultTTL: &ttl}
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	given := 10 * time.Second
+	doc, err := c.wrap([]byte(`{}`), &given, now)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"_cache_value": "e30=", "_expires_at": "2023-06-01T12:00:10Z"}`, string(doc))
+}
+
+func Test_Cache__shouldExpireAnyValue(t *testing.T) {
+	ttl := time.Minute
+	c := &cache{ttlField: "_expires_at"}
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, value := range [][]byte{{'t'}, []byte(`"plain"`), {0xff, 0x00}} {
+		doc, err := c.wrap(value, &ttl, now)
+		require.NoError(t, err)
+
+		unwrapped, expired, err := c.unwrap(doc, now)
+		require.NoError(t, err)
+		assert.False(t, expired)
+		assert.Equal(t, value, unwrapped)
+
+		_, expired, err = c.unwrap(doc, now.Add(ttl))
+		require.NoError(t, err)
+		assert.True(t, expired)
+	}
+}
+
+func Test_Cache__shouldNotMistakeValuesHoldingTheTtlField(t *testing.T) {
+	ttl := time.Minute
+	c := &cache{ttlField: "_expires_at", defaultTTL: &ttl}
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	stored := []byte(`{"name": "alpha", "_expires_at": "2000-01-01T00:00:00Z", "value": "not base64"}`)
+
+	value, expired, err := c.unwrap(stored, now)
+	require.NoError(t, err)
+	assert.False(t, expired)
+	assert.Equal(t, stored, value)
+
+	qry, err := json.Marshal(c.purgeQuery(now))
+	require.NoError(t, err)
+	assert.Contains(t, string(qry), `"exists":{"field":"_cache_value"}`)
+}
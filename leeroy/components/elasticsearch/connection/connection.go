@@ -0,0 +1,322 @@
+package connection
+
+import (
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+var (
+	connectionsMu sync.Mutex
+	connections   = map[string]*sharedConnection{}
+)
+
+// settingFields are the fields holding the settings of a connection, which must match between components sharing one.
+var settingFields = []string{
+	"addresses", "username", "password", "cloud_id", "api_key", "service_token", "certificate_fingerprint",
+	"tls", "max_retries", "retry_on_status", "compress", "discover_nodes_on_start", "discover_nodes_interval", "headers",
+}
+
+// Fields returns the fields describing a connection to elasticsearch.
+func Fields() []*service.ConfigField {
+	return []*service.ConfigField{
+		service.NewStringField("connection").
+			Description("The name of the connection. Components referring to the same name share a single client and its pool of connections. The settings only need to be given by one of those components, in any order, with the others setting `connection_reference`; components giving them anyway must give the same settings. Leave empty to use a client of its own.").
+			Default(""),
+		service.NewBoolField("connection_reference").
+			Description("Use the settings of the named `connection` as given by another component, ignoring the settings of this component. Requests fail until a component gives the settings of the connection.").
+			Default(false),
+		service.NewStringListField("addresses").Description("A list of Elasticsearch addresses to connect to.").Default([]string{}),
+		service.NewStringField("username").Description("The username to use for authentication.").Default(""),
+		service.NewStringField("password").Description("The password to use for authentication.").Secret().Default(""),
+		service.NewStringField("cloud_id").Description("The cloud ID to use for authentication.").Default(""),
+		service.NewStringField("api_key").Description("The API key to use for authentication.").Secret().Default(""),
+		service.NewStringField("service_token").Description("The service token to use for authentication.").Secret().Default(""),
+		service.NewStringField("certificate_fingerprint").Description("The certificate fingerprint to use for authentication.").Default(""),
+		service.NewTLSToggledField("tls"),
+		service.NewIntField("max_retries").
+			Description("How many times a failed request is retried. Set to `0` to disable retries.").
+			Default(3).
+			Advanced(),
+		service.NewIntListField("retry_on_status").
+			Description("The HTTP status codes of responses to retry.").
+			Default([]int{502, 503, 504}).
+			Advanced(),
+		service.NewBoolField("compress").
+			Description("Compress the bodies of requests using gzip.").
+			Default(false).
+			Advanced(),
+		service.NewBoolField("discover_nodes_on_start").
+			Description("Discover the nodes of the cluster when connecting, spreading requests over all of them.").
+			Default(false).
+			Advanced(),
+		service.NewDurationField("discover_nodes_interval").
+			Description("How often to discover the nodes of the cluster again. Set to `0s` to disable.").
+			Default("0s").
+			Advanced(),
+		service.NewStringMapField("headers").
+			Description("Headers to send along with every request.").
+			Default(map[string]any{}).
+			Advanced(),
+	}
+}
+
+// Connection is a handle on a possibly shared elasticsearch client. The client is released once all handles on it
+// are closed.
+type Connection struct {
+	shared *sharedConnection
+	once   sync.Once
+}
+
+// Client returns the elasticsearch client of the connection. The client of a named connection can be handed out
+// before any component gave the settings of the connection, its requests failing until one does.
+func (c *Connection) Client() *elasticsearch.TypedClient {
+	return c.shared.client
+}
+
+// Close releases the handle. Closing a handle more than once has no effect.
+func (c *Connection) Close() error {
+	c.once.Do(c.shared.release)
+	return nil
+}
+
+type sharedConnection struct {
+	name string
+
+	// client is the client handed out, which performs its requests through the configured client of a named connection
+	client *elasticsearch.TypedClient
+
+	// settings, configured and transport are set once a component gave the settings of the connection
+	mu         sync.RWMutex
+	settings   map[string]any
+	configured *elasticsearch.TypedClient
+	transport  *http.Transport
+
+	// refs is the number of open handles, guarded by connectionsMu
+	refs int
+}
+
+func newSharedConnection(name string) *sharedConnection {
+	result := &sharedConnection{name: name}
+	result.client = &elasticsearch.TypedClient{API: typedapi.New(result)}
+	return result
+}
+
+// configure builds the client of the connection from the settings of the component giving them first. Components
+// giving the settings later must give the same settings.
+func (s *sharedConnection) configure(conf *service.ParsedConfig, settings map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.settings != nil {
+		if !reflect.DeepEqual(settings, s.settings) {
+			return fmt.Errorf("connection %q is already configured with different settings", s.name)
+		}
+		return nil
+	}
+
+	cl, transport, err := newClient(conf)
+	if err != nil {
+		return err
+	}
+
+	s.settings, s.configured, s.transport = settings, cl, transport
+	return nil
+}
+
+// Perform sends the requests of the handed out client through the configured one.
+func (s *sharedConnection) Perform(req *http.Request) (*http.Response, error) {
+	s.mu.RLock()
+	cl := s.configured
+	s.mu.RUnlock()
+
+	if cl == nil {
+		return nil, fmt.Errorf("connection %q has no settings, give them with one of the components using it", s.name)
+	}
+
+	return cl.Perform(req)
+}
+
+func (s *sharedConnection) release() {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if s.refs--; s.refs > 0 {
+		return
+	}
+
+	if s.name != "" && connections[s.name] == s {
+		delete(connections, s.name)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.transport != nil {
+		s.transport.CloseIdleConnections()
+	}
+}
+
+// FromConfig opens a connection described by the fields of Fields, sharing the client of the named connection with
+// the other components referring to it. Components referring to a named connection never build a client of their own,
+// whichever order the components are built in.
+func FromConfig(conf *service.ParsedConfig) (*Connection, error) {
+	name, err := conf.FieldString("connection")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection: %w", err)
+	}
+
+	reference, err := conf.FieldBool("connection_reference")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection_reference: %w", err)
+	}
+
+	if name == "" {
+		if reference {
+			return nil, fmt.Errorf("a connection reference requires a connection name")
+		}
+
+		cl, transport, err := newClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &Connection{shared: &sharedConnection{client: cl, configured: cl, transport: transport, refs: 1}}, nil
+	}
+
+	var settings map[string]any
+	if !reference {
+		if settings, err = settingsFromConfig(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	shared, fnd := connections[name]
+	if !fnd {
+		shared = newSharedConnection(name)
+	}
+
+	if !reference {
+		if err := shared.configure(conf, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	connections[name] = shared
+	shared.refs++
+	return &Connection{shared: shared}, nil
+}
+
+func newClient(conf *service.ParsedConfig) (*elasticsearch.TypedClient, *http.Transport, error) {
+	cfg, transport, err := clientConfigFromConfig(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl, err := elasticsearch.NewTypedClient(*cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create the elasticsearch client: %w", err)
+	}
+
+	return cl, transport, nil
+}
+
+func settingsFromConfig(conf *service.ParsedConfig) (map[string]any, error) {
+	result := map[string]any{}
+	for _, f := range settingFields {
+		v, err := conf.FieldAny(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f, err)
+		}
+		result[f] = v
+	}
+
+	return result, nil
+}
+
+func clientConfigFromConfig(conf *service.ParsedConfig) (*elasticsearch.Config, *http.Transport, error) {
+	result := &elasticsearch.Config{}
+
+	addresses, err := conf.FieldStringList("addresses")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse addresses: %w", err)
+	}
+	if len(addresses) > 0 {
+		result.Addresses = addresses
+	}
+
+	if result.Username, err = conf.FieldString("username"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse username: %w", err)
+	}
+
+	if result.Password, err = conf.FieldString("password"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse password: %w", err)
+	}
+
+	if result.CloudID, err = conf.FieldString("cloud_id"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse cloud_id: %w", err)
+	}
+
+	if result.APIKey, err = conf.FieldString("api_key"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse api_key: %w", err)
+	}
+
+	if result.ServiceToken, err = conf.FieldString("service_token"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse service_token: %w", err)
+	}
+
+	if result.CertificateFingerprint, err = conf.FieldString("certificate_fingerprint"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate_fingerprint: %w", err)
+	}
+
+	if result.MaxRetries, err = conf.FieldInt("max_retries"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse max_retries: %w", err)
+	}
+	result.DisableRetry = result.MaxRetries <= 0
+
+	if result.RetryOnStatus, err = conf.FieldIntList("retry_on_status"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse retry_on_status: %w", err)
+	}
+
+	if result.CompressRequestBody, err = conf.FieldBool("compress"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse compress: %w", err)
+	}
+
+	if result.DiscoverNodesOnStart, err = conf.FieldBool("discover_nodes_on_start"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse discover_nodes_on_start: %w", err)
+	}
+
+	if result.DiscoverNodesInterval, err = conf.FieldDuration("discover_nodes_interval"); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse discover_nodes_interval: %w", err)
+	}
+
+	headers, err := conf.FieldStringMap("headers")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse headers: %w", err)
+	}
+	if len(headers) > 0 {
+		result.Header = http.Header{}
+		for k, v := range headers {
+			result.Header.Set(k, v)
+		}
+	}
+
+	tlsConf, tlsEnabled, err := conf.FieldTLSToggled("tls")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse tls: %w", err)
+	}
+
+	// -- a transport of our own, so its idle connections can be closed once the connection is released
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsEnabled {
+		transport.TLSClientConfig = tlsConf
+	}
+	result.Transport = transport
+
+	return result, transport, nil
+}
@@ -0,0 +1,132 @@
+package connection
+
+import (
+	"context"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func parse(t *testing.T, yaml string) *service.ParsedConfig {
+	conf, err := service.NewConfigSpec().Fields(Fields()...).ParseYAML(yaml, nil)
+	require.NoError(t, err)
+	return conf
+}
+
+func Test_Connection__shouldShareNamedClient(t *testing.T) {
+	first, err := FromConfig(parse(t, "connection: shared\naddresses: [http://es:9200]\ncompress: true"))
+	require.NoError(t, err)
+
+	byName, err := FromConfig(parse(t, "connection: shared\nconnection_reference: true"))
+	require.NoError(t, err)
+
+	same, err := FromConfig(parse(t, "connection: shared\naddresses: [http://es:9200]\ncompress: true"))
+	require.NoError(t, err)
+
+	assert.Same(t, first.Client(), byName.Client())
+	assert.Same(t, first.Client(), same.Client())
+
+	_, err = FromConfig(parse(t, "connection: shared\naddresses: [http://other:9200]"))
+	assert.ErrorContains(t, err, "different settings")
+
+	// -- the connection is kept until the last handle is closed
+	require.NoError(t, first.Close())
+	require.NoError(t, first.Close())
+	require.NoError(t, byName.Close())
+	assert.Contains(t, connections, "shared")
+
+	require.NoError(t, same.Close())
+	assert.NotContains(t, connections, "shared")
+}
+
+func Test_Connection__shouldNotShareUnnamedClient(t *testing.T) {
+	first, err := FromConfig(parse(t, "addresses: [http://es:9200]"))
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := FromConfig(parse(t, "addresses: [http://es:9200]"))
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.NotSame(t, first.Client(), second.Client())
+}
+
+func Test_Connection__shouldConfigureAfterReference(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	}))
+	defer srv.Close()
+
+	byName, err := FromConfig(parse(t, "connection: lazy\nconnection_reference: true"))
+	require.NoError(t, err)
+	defer byName.Close()
+
+	// -- a referenced connection does not fall back to a default address
+	_, err = byName.Client().Ping().Do(context.Background())
+	assert.ErrorContains(t, err, "has no settings")
+
+	configured, err := FromConfig(parse(t, "connection: lazy\naddresses: ["+srv.URL+"]"))
+	require.NoError(t, err)
+	defer configured.Close()
+
+	assert.Same(t, configured.Client(), byName.Client())
+
+	ok, err := byName.Client().Ping().Do(context.Background())
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func Test_Connection__shouldConfigureDefaultSettings(t *testing.T) {
+	conn, err := FromConfig(parse(t, "connection: defaults"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.NotNil(t, conn.shared.configured)
+
+	_, err = FromConfig(parse(t, "connection_reference: true"))
+	assert.ErrorContains(t, err, "requires a connection name")
+}
+
+func Test_Connection__shouldBuildClientConfig(t *testing.T) {
+	cfg, transport, err := clientConfigFromConfig(parse(t, `
+addresses: [https://es:9200]
+max_retries: 0
+retry_on_status: [429]
+headers:
+  x-tenant: acme
+tls:
+  enabled: true
+  skip_cert_verify: true
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"https://es:9200"}, cfg.Addresses)
+	assert.True(t, cfg.DisableRetry)
+	assert.Equal(t, []int{429}, cfg.RetryOnStatus)
+	assert.Equal(t, "acme", cfg.Header.Get("X-Tenant"))
+	assert.Same(t, transport, cfg.Transport)
+	require.NotNil(t, transport.TLSClientConfig)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+}
+
+func Test_FieldRefresh__shouldParsePolicies(t *testing.T) {
+	spec := service.NewConfigSpec().Field(RefreshField())
+
+	for yaml, expected := range map[string]refresh.Refresh{
+		"{}":                refresh.True,
+		"refresh: true":     refresh.True,
+		"refresh: false":    refresh.False,
+		"refresh: wait_for": refresh.Waitfor,
+	} {
+		conf, err := spec.ParseYAML(yaml, nil)
+		require.NoError(t, err)
+
+		rp, err := FieldRefresh(conf)
+		require.NoError(t, err)
+		assert.Equal(t, expected, rp, yaml)
+	}
+}
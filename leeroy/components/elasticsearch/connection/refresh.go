@@ -0,0 +1,31 @@
+package connection
+
+import (
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+)
+
+// RefreshField is the field holding the refresh policy to apply on writes.
+func RefreshField() *service.ConfigField {
+	return service.NewStringAnnotatedEnumField("refresh", map[string]string{
+		"true":     "Refresh the affected shards right after the write, making the change visible to searches immediately.",
+		"false":    "Do not refresh, the change becomes visible once the index refreshes periodically.",
+		"wait_for": "Wait for the next periodic refresh before returning, without forcing one.",
+	}).
+		Description("The refresh policy to apply on writes, trading read-after-write consistency for throughput.").
+		Default("true")
+}
+
+// FieldRefresh returns the refresh policy held by the refresh field.
+func FieldRefresh(conf *service.ParsedConfig) (refresh.Refresh, error) {
+	var result refresh.Refresh
+
+	v, err := conf.FieldString("refresh")
+	if err != nil {
+		return result, fmt.Errorf("failed to parse refresh: %w", err)
+	}
+
+	err = result.UnmarshalText([]byte(v))
+	return result, err
+}
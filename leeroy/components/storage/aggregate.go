@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Metric functions of an aggregation.
+const (
+	MetricCount = "count"
+	MetricSum   = "sum"
+	MetricAvg   = "avg"
+	MetricMin   = "min"
+	MetricMax   = "max"
+)
+
+// AggregateClient is implemented by clients able to count and aggregate documents within the store itself.
+type AggregateClient interface {
+	// Count returns the number of documents in the collection matching the query.
+	Count(ctx context.Context, collection string, q any) (int64, error)
+
+	// Aggregate groups the documents in the collection matching the query and calculates the metrics per group.
+	Aggregate(ctx context.Context, collection string, q any, agg Aggregation) ([]map[string]any, error)
+}
+
+// Aggregation describes how to group documents and what to calculate for each group. Without any group fields, all
+// documents are aggregated into a single group.
+//
+// Each resulting group is an object holding the value of every group field under its dotted path and the value of every
+// metric under its name. The groups are ordered by the values of the group fields. Documents missing a group field are
+// grouped under a null value.
+type Aggregation struct {
+	GroupBy [][]string
+	Metrics []Metric
+}
+
+// Metric is a value calculated over the documents of a group. All functions other than count only take the numeric
+// values of the field into account; avg, min and max result in null if there are none.
+type Metric struct {
+	Name string
+	Func string
+	Path []string
+}
+
+func (m Metric) Field() string {
+	return strings.Join(m.Path, ".")
+}
+
+var metricPattern = regexp.MustCompile(`^\s*(\w+)\s*\(\s*([^()]*?)\s*\)\s*$`)
+
+// ParseMetric parses a metric expression like `count()`, `sum(total)` or `max(customer.age)`.
+func ParseMetric(name string, expr string) (Metric, error) {
+	m := metricPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return Metric{}, fmt.Errorf("invalid metric %q, expected a function like sum(field)", expr)
+	}
+
+	result := Metric{Name: name, Func: strings.ToLower(m[1])}
+	switch result.Func {
+	case MetricCount:
+		if m[2] != "" {
+			return Metric{}, fmt.Errorf("metric %q: count does not take a field", name)
+		}
+	case MetricSum, MetricAvg, MetricMin, MetricMax:
+		if m[2] == "" {
+			return Metric{}, fmt.Errorf("metric %q: %s requires a field", name, result.Func)
+		}
+		result.Path = strings.Split(m[2], ".")
+	default:
+		return Metric{}, fmt.Errorf("metric %q: unknown function %q", name, m[1])
+	}
+
+	return result, nil
+}
+
+// Count counts the documents using the aggregation support of the client if available, or by reading all matching
+// documents if not.
+func Count(ctx context.Context, cl Client, collection string, q any) (int64, error) {
+	if ac, ok := cl.(AggregateClient); ok {
+		return ac.Count(ctx, collection, q)
+	}
+
+	cur, err := cl.List(ctx, collection, q, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	var result int64
+	for cur.HasNext() {
+		if _, err := cur.Read(); err != nil {
+			return 0, err
+		}
+		result++
+	}
+
+	return result, nil
+}
+
+// Aggregate aggregates the documents using the aggregation support of the client if available, or by reading all
+// matching documents if not.
+func Aggregate(ctx context.Context, cl Client, collection string, q any, agg Aggregation) ([]map[string]any, error) {
+	if ac, ok := cl.(AggregateClient); ok {
+		return ac.Aggregate(ctx, collection, q, agg)
+	}
+
+	cur, err := cl.List(ctx, collection, q, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	return aggregateCursor(cur, agg)
+}
+
+type aggregateGroup struct {
+	values []any
+	count  int64
+	sums   []float64
+	counts []int64
+	mins   []float64
+	maxs   []float64
+}
+
+func aggregateCursor(cur Cursor, agg Aggregation) ([]map[string]any, error) {
+	groups := map[string]*aggregateGroup{}
+
+	for cur.HasNext() {
+		doc, err := cur.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]any, len(agg.GroupBy))
+		for i, path := range agg.GroupBy {
+			values[i], _ = lookupPath(doc, path)
+		}
+
+		// -- the formatted values identify the group, which is sufficient for the scalar values documents are grouped by
+		id := fmt.Sprintf("%#v", values)
+		grp, fnd := groups[id]
+		if !fnd {
+			grp = &aggregateGroup{
+				values: values,
+				sums:   make([]float64, len(agg.Metrics)),
+				counts: make([]int64, len(agg.Metrics)),
+				mins:   make([]float64, len(agg.Metrics)),
+				maxs:   make([]float64, len(agg.Metrics)),
+			}
+			groups[id] = grp
+		}
+
+		grp.count++
+		for i, m := range agg.Metrics {
+			if m.Func == MetricCount {
+				continue
+			}
+
+			v, _ := lookupPath(doc, m.Path)
+			f, ok := toFloat(v)
+			if !ok {
+				continue
+			}
+
+			if grp.counts[i] == 0 || f < grp.mins[i] {
+				grp.mins[i] = f
+			}
+			if grp.counts[i] == 0 || f > grp.maxs[i] {
+				grp.maxs[i] = f
+			}
+			grp.sums[i] += f
+			grp.counts[i]++
+		}
+	}
+
+	// -- without group fields there is always a single group, even when there are no documents
+	if len(agg.GroupBy) == 0 && len(groups) == 0 {
+		groups[""] = &aggregateGroup{
+			sums:   make([]float64, len(agg.Metrics)),
+			counts: make([]int64, len(agg.Metrics)),
+			mins:   make([]float64, len(agg.Metrics)),
+			maxs:   make([]float64, len(agg.Metrics)),
+		}
+	}
+
+	sorted := make([]*aggregateGroup, 0, len(groups))
+	for _, grp := range groups {
+		sorted = append(sorted, grp)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return lessByValues(sorted[i].values, sorted[j].values)
+	})
+
+	var result []map[string]any
+	for _, grp := range sorted {
+		res := map[string]any{}
+		for i, path := range agg.GroupBy {
+			res[strings.Join(path, ".")] = grp.values[i]
+		}
+
+		for i, m := range agg.Metrics {
+			switch {
+			case m.Func == MetricCount:
+				res[m.Name] = grp.count
+			case m.Func == MetricSum:
+				res[m.Name] = grp.sums[i]
+			case grp.counts[i] == 0:
+				res[m.Name] = nil
+			case m.Func == MetricAvg:
+				res[m.Name] = grp.sums[i] / float64(grp.counts[i])
+			case m.Func == MetricMin:
+				res[m.Name] = grp.mins[i]
+			case m.Func == MetricMax:
+				res[m.Name] = grp.maxs[i]
+			}
+		}
+
+		result = append(result, res)
+	}
+
+	return result, nil
+}
+
+// lessByValues orders lists of group values, with null values sorting first.
+func lessByValues(a, b []any) bool {
+	for i := range a {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return true
+		case b[i] == nil:
+			return false
+		}
+
+		if cmp, ok := compareValues(a[i], b[i]); ok && cmp != 0 {
+			return cmp < 0
+		}
+	}
+
+	return false
+}
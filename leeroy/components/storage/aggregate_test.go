@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_ParseMetric(t *testing.T) {
+	m, err := ParseMetric("revenue", "sum( order.total )")
+	require.NoError(t, err)
+	assert.Equal(t, Metric{Name: "revenue", Func: MetricSum, Path: []string{"order", "total"}}, m)
+
+	m, err = ParseMetric("orders", "COUNT()")
+	require.NoError(t, err)
+	assert.Equal(t, Metric{Name: "orders", Func: MetricCount}, m)
+
+	for _, expr := range []string{"sum()", "count(total)", "median(total)", "total"} {
+		_, err := ParseMetric("m", expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func Test_Aggregate__shouldAggregateWithoutNativeSupport(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("aggregate_fallback")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"customer": "a", "total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"customer": "a", "total": 15}))
+	require.NoError(t, cl.Set(tCtx, "orders", "3", map[string]any{"customer": "b", "total": "n/a"}))
+	require.NoError(t, cl.Set(tCtx, "orders", "4", map[string]any{"total": 10}))
+
+	cnt, err := Count(tCtx, cl, "orders", `total > 6`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cnt)
+
+	groups, err := Aggregate(tCtx, cl, "orders", "", Aggregation{
+		GroupBy: [][]string{{"customer"}},
+		Metrics: []Metric{
+			{Name: "orders", Func: MetricCount},
+			{Name: "revenue", Func: MetricSum, Path: []string{"total"}},
+			{Name: "average", Func: MetricAvg, Path: []string{"total"}},
+			{Name: "largest", Func: MetricMax, Path: []string{"total"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]any{
+		{"customer": nil, "orders": int64(1), "revenue": float64(10), "average": float64(10), "largest": float64(10)},
+		{"customer": "a", "orders": int64(2), "revenue": float64(20), "average": float64(10), "largest": float64(15)},
+		{"customer": "b", "orders": int64(1), "revenue": float64(0), "average": nil, "largest": nil},
+	}, groups)
+
+	groups, err = Aggregate(tCtx, cl, "missing", "", Aggregation{
+		Metrics: []Metric{{Name: "orders", Func: MetricCount}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]any{{"orders": int64(0)}}, groups)
+}
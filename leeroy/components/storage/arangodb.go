@@ -1,24 +1,72 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/arangodb/go-driver/cluster"
 	"github.com/arangodb/go-driver/http"
+	"github.com/arangodb/go-driver/vst"
+	"github.com/arangodb/go-driver/vst/protocol"
 	"github.com/benthosdev/benthos/v4/public/service"
+	"io"
+	nethttp "net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func IsArangodbConfigured(conf *service.ParsedConfig) bool {
-	_, err := conf.FieldStringList("arangodb", "urls")
-	return err == nil
+// arangodbMaxCount is the count used to express an unlimited LIMIT, being the largest integer AQL can represent exactly.
+const arangodbMaxCount = 1<<53 - 1
+
+func init() {
+	if err := RegisterDriver("arangodb", ArangodbConfigFields(), NewArangodbClientFromConfig); err != nil {
+		panic(err)
+	}
 }
 
 func ArangodbConfigFields() []*service.ConfigField {
 	return []*service.ConfigField{
-		service.NewStringListField("urls"),
-		service.NewStringField("username"),
-		service.NewStringField("password"),
-		service.NewStringField("database"),
+		service.NewStringListField("urls").
+			Description("The endpoints of the arangodb servers or coordinators. Use `https://` endpoints to connect using TLS."),
+		service.NewStringField("username").
+			Description("The username to authenticate with.").
+			Default(""),
+		service.NewStringField("password").
+			Description("The password to authenticate with.").
+			Secret().
+			Default(""),
+		service.NewStringField("database").
+			Description("The database holding the collections."),
+		service.NewStringAnnotatedEnumField("authentication", map[string]string{
+			"basic": "Send the username and password with every request.",
+			"jwt":   "Authenticate using a JSON web token, obtained using the username and password unless a `token` is given.",
+		}).
+			Description("How to authenticate with arangodb.").
+			Default("basic"),
+		service.NewStringField("token").
+			Description("The JSON web token to authenticate with, for instance a superuser token signed with the secret of the cluster. This is only applicable for 'jwt' authentication").
+			Secret().
+			Default("").
+			Advanced(),
+		service.NewStringAnnotatedEnumField("transport", map[string]string{
+			"http": "Use HTTP, the protocol supported by all arangodb versions.",
+			"vst":  "Use VelocyStream, which multiplexes requests over fewer connections. VelocyStream is no longer supported as of arangodb 3.12.",
+		}).
+			Description("The protocol to talk to arangodb with.").
+			Default("http").
+			Advanced(),
+		service.NewTLSField("tls").
+			Description("The TLS settings applied to `https://` endpoints.").
+			Advanced(),
+		service.NewDurationField("timeout").
+			Description("How long a request to arangodb may take.").
+			Default("1m").
+			Advanced(),
 	}
 }
 
@@ -43,154 +91,920 @@ func NewArangodbClientFromConfig(conf *service.ParsedConfig, mgr *service.Resour
 		return nil, fmt.Errorf("failed to get database field: %w", err)
 	}
 
-	var u []string
-	for _, v := range urls {
-		u = append(u, v)
+	conn, err := arangodbConnectionFromConfig(conf, urls)
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: u,
-	})
+	auth, err := arangodbAuthenticationFromConfig(conf, username, password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create arangodb connection: %w", err)
+		return nil, err
 	}
+
 	c, err := driver.NewClient(driver.ClientConfig{
 		Connection:     conn,
-		Authentication: driver.BasicAuthentication(username, password),
+		Authentication: auth,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create arangodb client: %w", err)
 	}
 
-	db, err := c.Database(context.Background(), database)
+	// -- the database is resolved on first use, so the store does not need to be available when starting up
+	return ArangodbClient{c, &arangodbDatabase{cl: c, name: database}, mgr.Logger()}, nil
+}
+
+func arangodbConnectionFromConfig(conf *service.ParsedConfig, urls []string) (driver.Connection, error) {
+	transport, err := conf.FieldString("transport")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transport field: %w", err)
+	}
+
+	tlsConf, err := conf.FieldTLS("tls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tls field: %w", err)
+	}
+
+	timeout, err := conf.FieldDuration("timeout")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeout field: %w", err)
+	}
+
+	var conn driver.Connection
+	switch transport {
+	case "vst":
+		conn, err = vst.NewConnection(vst.ConnectionConfig{
+			Endpoints:        urls,
+			TLSConfig:        tlsConf,
+			Transport:        protocol.TransportConfig{Version: protocol.Version1_1},
+			ConnectionConfig: cluster.ConnectionConfig{DefaultTimeout: timeout},
+		})
+	default:
+		conn, err = http.NewConnection(http.ConnectionConfig{
+			Endpoints:        urls,
+			TLSConfig:        tlsConf,
+			ConnectionConfig: cluster.ConnectionConfig{DefaultTimeout: timeout},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create arangodb connection: %w", err)
+	}
+
+	return conn, nil
+}
+
+func arangodbAuthenticationFromConfig(conf *service.ParsedConfig, username string, password string) (driver.Authentication, error) {
+	authentication, err := conf.FieldString("authentication")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authentication field: %w", err)
+	}
+
+	token, err := conf.FieldString("token")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get arangodb database: %w", err)
+		return nil, fmt.Errorf("failed to get token field: %w", err)
 	}
 
-	return ArangodbClient{c, db, mgr.Logger()}, nil
+	switch {
+	case authentication != "jwt":
+		return driver.BasicAuthentication(username, password), nil
+	case token != "":
+		return driver.RawAuthentication("bearer " + token), nil
+	default:
+		return driver.JWTAuthentication(username, password), nil
+	}
 }
 
 type ArangodbClient struct {
 	cl     driver.Client
-	db     driver.Database
+	db     *arangodbDatabase
 	logger *service.Logger
 }
 
+// arangodbDatabase resolves the database on first use. A failure to resolve it is not kept, so the next use tries again.
+// The connection itself reconnects by the request, so the resolved database remains valid when arangodb restarts.
+type arangodbDatabase struct {
+	cl   driver.Client
+	name string
+
+	mu sync.Mutex
+	db driver.Database
+}
+
+func (d *arangodbDatabase) get(ctx context.Context) (driver.Database, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.db != nil {
+		return d.db, nil
+	}
+
+	db, err := d.cl.Database(ctx, d.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get arangodb database %s: %w", d.name, err)
+	}
+
+	d.db = db
+	return db, nil
+}
+
+func (c ArangodbClient) collection(ctx context.Context, collection string) (driver.Collection, error) {
+	db, err := c.db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Collection(ctx, collection)
+}
+
+func (c ArangodbClient) query(ctx context.Context, query string, bindVars map[string]any) (driver.Cursor, error) {
+	db, err := c.db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Query(ctx, query, bindVars)
+}
+
+// Ping checks the database can be reached with the configured credentials.
+func (c ArangodbClient) Ping(ctx context.Context) error {
+	db, err := c.db.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Info(ctx); err != nil {
+		return fmt.Errorf("failed to reach arangodb database %s: %w", c.db.name, err)
+	}
+
+	return nil
+}
+
 func (c ArangodbClient) ParseQuery(config string) (any, error) {
 	return config, nil
 }
 
+// Merge updates the document by merging the given value into it, creating the document if it does not exist yet. The
+// merged document is returned.
 func (c ArangodbClient) Merge(ctx context.Context, collection string, key string, value map[string]any) (map[string]any, error) {
-	panic("not supported yet")
+	merged, _, err := c.MergeRevision(ctx, collection, key, value, "")
+	return merged, err
+}
+
+// MergeRevision merges the value into the document. If an expected revision is given, the document must exist and be
+// at that revision.
+func (c ArangodbClient) MergeRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (map[string]any, string, error) {
+	col, err := c.collection(ctx, collection)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	if expected != "" {
+		merged, rev, err := c.update(driver.WithRevision(ctx, expected), col, key, value)
+		return merged, rev, arangodbRevisionError(err)
+	}
+
+	merged, rev, err := c.update(ctx, col, key, value)
+	if err == nil || !driver.IsNotFoundGeneral(err) {
+		return merged, rev, err
+	}
+
+	// -- the document does not exist yet, so create it instead
+	var created map[string]any
+	meta, err := col.CreateDocument(driver.WithReturnNew(ctx, &created), arangodbDocument(key, value))
+	if err == nil {
+		return created, meta.Rev, nil
+	}
+
+	// -- someone else created the document in the meantime, merge into theirs
+	if driver.IsConflict(err) {
+		return c.update(ctx, col, key, value)
+	}
+
+	return nil, "", err
+}
+
+func (c ArangodbClient) update(ctx context.Context, col driver.Collection, key string, value map[string]any) (map[string]any, string, error) {
+	var merged map[string]any
+	meta, err := col.UpdateDocument(driver.WithReturnNew(driver.WithMergeObjects(ctx, true), &merged), key, value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return merged, meta.Rev, nil
 }
 
+// List executes a filtered query on the collection. The query is either an AQL filter expression, a
+// ParameterizedQuery holding an AQL filter expression with its bind parameters or a structured filter. Within AQL
+// expressions the document is available as `d`.
 func (c ArangodbClient) List(ctx context.Context, collection string, q any, pitEnabled bool, paging *PagingOpts) (Cursor, error) {
-	if q == nil {
-		return nil, fmt.Errorf("query is nil")
+	qry, err := c.parameterizedQuery(q)
+	if err != nil {
+		return nil, err
 	}
 
-	var qry string
-	switch qt := q.(type) {
-	case string:
-		qry = qt
-	default:
-		return nil, fmt.Errorf("query is not a valid arangodb query")
+	query, bindVars, err := c.buildQuery(collection, qry, paging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
+	}
+
+	if paging != nil && paging.PageSize > 0 {
+		ctx = driver.WithQueryBatchSize(ctx, int(paging.PageSize))
+	}
+
+	cursor, err := c.query(ctx, query, bindVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return &arangodbCursorWrapper{cursor, ctx}, nil
+}
+
+func (c ArangodbClient) EnsureCollection(ctx context.Context, collection string, spec CollectionSpec) error {
+	db, err := c.db.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	col, err := db.Collection(ctx, collection)
+	if driver.IsNotFoundGeneral(err) {
+		var opts *driver.CreateCollectionOptions
+		if spec.Edges {
+			opts = &driver.CreateCollectionOptions{Type: driver.CollectionTypeEdge}
+		}
+
+		col, err = db.CreateCollection(ctx, collection, opts)
+
+		// -- someone else created the collection in the meantime
+		if driver.IsConflict(err) {
+			col, err = db.Collection(ctx, collection)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ensure collection %s: %w", collection, err)
+	}
+
+	for _, idx := range spec.Indexes {
+		_, _, err := col.EnsurePersistentIndex(ctx, idx.Fields, &driver.EnsurePersistentIndexOptions{
+			Name:   idx.IndexName(collection),
+			Unique: idx.Unique,
+			Sparse: idx.Sparse,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to ensure index %s on %s: %w", idx.IndexName(collection), collection, err)
+		}
+	}
+
+	return nil
+}
+
+// EnsureExpiry creates a TTL index on the field, having arangodb remove documents once the time held by the field has
+// passed. Arangodb removes expired documents in the background, so they may linger for a while.
+func (c ArangodbClient) EnsureExpiry(ctx context.Context, collection string, path []string) error {
+	col, err := c.collection(ctx, collection)
+	if err != nil {
+		return fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	field := strings.Join(path, ".")
+	_, _, err = col.EnsureTTLIndex(ctx, field, 0, &driver.EnsureTTLIndexOptions{
+		Name: fmt.Sprintf("%s_%s_ttl", collection, strings.ReplaceAll(field, ".", "_")),
+	})
+
+	return err
+}
+
+func (c ArangodbClient) Count(ctx context.Context, collection string, q any) (int64, error) {
+	qry, err := c.parameterizedQuery(q)
+	if err != nil {
+		return 0, err
+	}
+
+	bv := newArangodbBindVars(qry)
+	if err := bv.bind("@collection", collection); err != nil {
+		return 0, err
+	}
+
+	query := "FOR d IN @@collection"
+	if len(qry.Query) > 0 {
+		query += fmt.Sprintf(" FILTER %s", qry.Query)
+	}
+	query += " COLLECT WITH COUNT INTO n RETURN n"
+
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
 	}
 
-	query, err := c.buildQuery(collection, qry, paging)
+	cursor, err := c.query(ctx, query, bv.vars)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer cursor.Close()
+
+	var result int64
+	if _, err := cursor.ReadDocument(ctx, &result); err != nil {
+		return 0, fmt.Errorf("failed to read count: %w", err)
+	}
+
+	return result, nil
+}
+
+func (c ArangodbClient) Aggregate(ctx context.Context, collection string, q any, agg Aggregation) ([]map[string]any, error) {
+	qry, err := c.parameterizedQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	query, bindVars, err := c.buildAggregateQuery(collection, qry, agg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	cursor, err := c.db.Query(ctx, query, map[string]interface{}{})
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
+	}
+
+	cursor, err := c.query(ctx, query, bindVars)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
+	defer cursor.Close()
+
+	var result []map[string]any
+	for cursor.HasMore() {
+		var group map[string]any
+		if _, err := cursor.ReadDocument(ctx, &group); err != nil {
+			return nil, fmt.Errorf("failed to read group: %w", err)
+		}
+		result = append(result, group)
+	}
+
+	return result, nil
+}
+
+// Link upserts the edge between the vertices, matching existing edges on their _from and _to attributes.
+func (c ArangodbClient) Link(ctx context.Context, collection string, from Vertex, to Vertex, value map[string]any) (map[string]any, error) {
+	// -- the value can not move the edge to other vertices
+	value = copyDocument(value)
+	for _, attr := range []string{"_key", "_id", "_rev", "_from", "_to"} {
+		delete(value, attr)
+	}
+
+	query := "UPSERT {_from: @from, _to: @to} INSERT MERGE(@value, {_from: @from, _to: @to}) UPDATE @value IN @@collection RETURN NEW"
+	bindVars := map[string]any{
+		"@collection": collection,
+		"from":        from.ID(),
+		"to":          to.ID(),
+		"value":       value,
+	}
+
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
+	}
+
+	cursor, err := c.query(ctx, query, bindVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link %s to %s: %w", from.ID(), to.ID(), err)
+	}
+	defer cursor.Close()
+
+	var result map[string]any
+	if _, err := cursor.ReadDocument(ctx, &result); err != nil {
+		return nil, fmt.Errorf("failed to read edge: %w", err)
+	}
+
+	return result, nil
+}
+
+func (c ArangodbClient) Unlink(ctx context.Context, collection string, from Vertex, to Vertex) error {
+	query := "FOR e IN @@collection FILTER e._from == @from AND e._to == @to REMOVE e IN @@collection RETURN 1"
+	bindVars := map[string]any{
+		"@collection": collection,
+		"from":        from.ID(),
+		"to":          to.ID(),
+	}
+
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
+	}
+
+	cursor, err := c.query(ctx, query, bindVars)
+	if err != nil {
+		if driver.IsNotFoundGeneral(err) {
+			return ErrNotFound
+		}
+
+		return fmt.Errorf("failed to unlink %s from %s: %w", from.ID(), to.ID(), err)
+	}
+	defer cursor.Close()
+
+	if !cursor.HasMore() {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (c ArangodbClient) Traverse(ctx context.Context, collection string, start Vertex, opts TraversalOpts) (Cursor, error) {
+	query, bindVars, err := c.buildTraversal(collection, start, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build traversal: %w", err)
+	}
+
+	if c.logger != nil {
+		c.logger.Debugf("executing %s", query)
+	}
+
+	cursor, err := c.query(ctx, query, bindVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute traversal: %w", err)
+	}
 
 	return &arangodbCursorWrapper{cursor, ctx}, nil
 }
 
+// buildTraversal creates the AQL query traversing the edge collection breadth first, visiting every vertex only once.
+// The depths are validated integers and written into the query, as is the direction; all other values are bound.
+func (c ArangodbClient) buildTraversal(collection string, start Vertex, opts TraversalOpts) (string, map[string]any, error) {
+	var direction string
+	switch opts.Direction {
+	case "", DirectionOutbound:
+		direction = "OUTBOUND"
+	case DirectionInbound:
+		direction = "INBOUND"
+	case DirectionAny:
+		direction = "ANY"
+	default:
+		return "", nil, fmt.Errorf("invalid direction %q", opts.Direction)
+	}
+
+	if opts.MinDepth < 0 || opts.MaxDepth < 1 || opts.MinDepth > opts.MaxDepth {
+		return "", nil, fmt.Errorf("invalid depth range %d..%d", opts.MinDepth, opts.MaxDepth)
+	}
+
+	query := fmt.Sprintf("FOR v, e, p IN %d..%d %s @start @@collection OPTIONS {order: \"bfs\", uniqueVertices: \"global\"} "+
+		"RETURN {vertex: v, edge: e, depth: LENGTH(p.edges)}", opts.MinDepth, opts.MaxDepth, direction)
+
+	return query, map[string]any{
+		"@collection": collection,
+		"start":       start.ID(),
+	}, nil
+}
+
+// parameterizedQuery turns the supported query types into an AQL filter expression with its bind parameters.
+func (c ArangodbClient) parameterizedQuery(q any) (ParameterizedQuery, error) {
+	switch qt := q.(type) {
+	case nil:
+		return ParameterizedQuery{}, fmt.Errorf("query is nil")
+	case string:
+		return ParameterizedQuery{Query: qt}, nil
+	case ParameterizedQuery:
+		return qt, nil
+	case *Filter:
+		return c.buildFilter(qt), nil
+	default:
+		return ParameterizedQuery{}, fmt.Errorf("query is not a valid arangodb query")
+	}
+}
+
 func (c ArangodbClient) Get(ctx context.Context, collection string, key string) (map[string]any, error) {
-	col, err := c.db.Collection(ctx, collection)
+	doc, _, err := c.GetRevision(ctx, collection, key)
+	return doc, err
+}
+
+func (c ArangodbClient) GetRevision(ctx context.Context, collection string, key string) (map[string]any, string, error) {
+	col, err := c.collection(ctx, collection)
 	if err != nil {
 		if driver.IsNotFoundGeneral(err) {
-			return nil, nil
+			return nil, "", ErrNotFound
 		}
 
-		return nil, fmt.Errorf("failed to get collection: %w", err)
+		return nil, "", fmt.Errorf("failed to get collection: %w", err)
 	}
 
 	var target map[string]any
-	_, err = col.ReadDocument(ctx, key, &target)
-	return target, err
+	meta, err := col.ReadDocument(ctx, key, &target)
+	if err != nil {
+		if driver.IsNotFoundGeneral(err) {
+			return nil, "", ErrNotFound
+		}
+
+		return nil, "", err
+	}
+
+	return target, meta.Rev, nil
 }
 
 func (c ArangodbClient) Set(ctx context.Context, collection string, key string, value map[string]any) error {
-	col, err := c.db.Collection(ctx, collection)
-	if err != nil {
-		return fmt.Errorf("failed to get collection: %w", err)
-	}
+	_, err := c.SetRevision(ctx, collection, key, value, "")
+	return err
+}
 
-	fnd, err := col.DocumentExists(ctx, key)
+// SetRevision replaces the document. If an expected revision is given, the document must exist and be at that
+// revision.
+func (c ArangodbClient) SetRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (string, error) {
+	col, err := c.collection(ctx, collection)
 	if err != nil {
-		return fmt.Errorf("failed to check if document exists: %w", err)
+		return "", fmt.Errorf("failed to get collection: %w", err)
 	}
 
-	// -- override the key
-	value["_key"] = key
+	doc := arangodbDocument(key, value)
 
-	if fnd {
-		_, err = col.ReplaceDocument(ctx, key, value)
-		return err
-	} else {
-		_, err = col.CreateDocument(ctx, value)
-		return err
+	if expected != "" {
+		meta, err := col.ReplaceDocument(driver.WithRevision(ctx, expected), key, doc)
+		return meta.Rev, arangodbRevisionError(err)
 	}
+
+	// -- replace the document if it exists, within the same request creating it
+	meta, err := col.CreateDocument(driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace), doc)
+	return meta.Rev, err
 }
 
 func (c ArangodbClient) Add(ctx context.Context, collection string, key string, value map[string]any) error {
-	col, err := c.db.Collection(ctx, collection)
+	col, err := c.collection(ctx, collection)
 	if err != nil {
 		return fmt.Errorf("failed to get collection: %w", err)
 	}
 
-	// -- override the key
-	value["_key"] = key
+	_, err = col.CreateDocument(ctx, arangodbDocument(key, value))
+	if driver.IsConflict(err) {
+		return service.ErrKeyAlreadyExists
+	}
 
-	_, err = col.CreateDocument(ctx, value)
 	return err
 }
 
 func (c ArangodbClient) Delete(ctx context.Context, collection string, key string) error {
-	col, err := c.db.Collection(ctx, collection)
+	return c.DeleteRevision(ctx, collection, key, "")
+}
+
+func (c ArangodbClient) DeleteRevision(ctx context.Context, collection string, key string, expected string) error {
+	col, err := c.collection(ctx, collection)
 	if err != nil {
+		if driver.IsNotFoundGeneral(err) {
+			return ErrNotFound
+		}
+
 		return fmt.Errorf("failed to get collection: %w", err)
 	}
 
+	if expected != "" {
+		ctx = driver.WithRevision(ctx, expected)
+	}
+
 	_, err = col.RemoveDocument(ctx, key)
-	return err
+	return arangodbRevisionError(err)
+}
+
+// arangodbRevisionError translates the error returned by a conditional write.
+func arangodbRevisionError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case driver.IsPreconditionFailed(err):
+		return fmt.Errorf("%w: %s", ErrRevisionMismatch, err)
+	case driver.IsNotFoundGeneral(err):
+		return ErrNotFound
+	default:
+		return err
+	}
+}
+
+// Bulk applies the operations using the multi-document API, sending a single request per collection and operation.
+func (c ArangodbClient) Bulk(ctx context.Context, ops []BulkOperation) ([]error, error) {
+	type group struct {
+		collection string
+		operation  string
+	}
+
+	// -- group the operations while keeping track of their position
+	var order []group
+	groups := map[group][]int{}
+	for i, op := range ops {
+		g := group{op.Collection, op.Operation}
+		if _, fnd := groups[g]; !fnd {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	errs := make([]error, len(ops))
+	for _, g := range order {
+		idx := groups[g]
+
+		col, err := c.collection(ctx, g.collection)
+		if err != nil {
+			for _, i := range idx {
+				errs[i] = fmt.Errorf("failed to get collection: %w", err)
+			}
+			continue
+		}
+
+		var res driver.ErrorSlice
+		switch g.operation {
+		case "set", "add":
+			docs := make([]map[string]any, len(idx))
+			for j, i := range idx {
+				docs[j] = arangodbDocument(ops[i].Key, ops[i].Value)
+			}
+
+			cctx := ctx
+			if g.operation == "set" {
+				cctx = driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace)
+			}
+
+			_, res, err = col.CreateDocuments(cctx, docs)
+		case "delete":
+			keys := make([]string, len(idx))
+			for j, i := range idx {
+				keys[j] = ops[i].Key
+			}
+
+			_, res, err = col.RemoveDocuments(ctx, keys)
+		default:
+			err = fmt.Errorf("operation %q is not supported in bulk", g.operation)
+		}
+
+		for j, i := range idx {
+			switch {
+			case err != nil:
+				errs[i] = err
+			case j < len(res) && res[j] != nil:
+				errs[i] = arangodbBulkError(res[j])
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// arangodbDocument returns a copy of the value holding the key, leaving the value of the caller untouched.
+func arangodbDocument(key string, value map[string]any) map[string]any {
+	result := make(map[string]any, len(value)+1)
+	for k, v := range value {
+		result[k] = v
+	}
+	result["_key"] = key
+
+	return result
+}
+
+func arangodbBulkError(err error) error {
+	switch {
+	case driver.IsConflict(err):
+		return service.ErrKeyAlreadyExists
+	case driver.IsNotFoundGeneral(err):
+		return ErrNotFound
+	default:
+		return err
+	}
 }
 
 func (c ArangodbClient) Close() error {
 	return nil
 }
 
-func (c ArangodbClient) buildQuery(collection string, q string, paging *PagingOpts) (string, error) {
-	result := fmt.Sprintf("FOR d IN %s", collection)
+// buildQuery creates the AQL query for listing the documents of a collection. The collection, paging and filter values
+// are passed as bind variables.
+func (c ArangodbClient) buildQuery(collection string, q ParameterizedQuery, paging *PagingOpts) (string, map[string]any, error) {
+	bv := newArangodbBindVars(q)
+	bind := bv.bind
 
-	var parts []string
+	if err := bind("@collection", collection); err != nil {
+		return "", nil, err
+	}
+
+	parts := []string{"FOR d IN @@collection"}
 
-	if len(q) > 0 {
-		parts = append(parts, fmt.Sprintf("FILTER %s", q))
+	if len(q.Query) > 0 {
+		parts = append(parts, fmt.Sprintf("FILTER %s", q.Query))
 	}
 
-	if paging != nil {
-		parts = append(parts, fmt.Sprintf("LIMIT %d, %d", paging.Offset, paging.Size))
+	if paging != nil && len(paging.Sort) > 0 {
+		var fields []string
+		for i, sf := range paging.Sort {
+			attr := "d"
+			for j, p := range sf.Path {
+				name := fmt.Sprintf("s%d_%d", i, j)
+				if err := bind(name, p); err != nil {
+					return "", nil, err
+				}
+				attr += ".@" + name
+			}
+
+			if sf.Descending {
+				attr += " DESC"
+			} else {
+				attr += " ASC"
+			}
+
+			fields = append(fields, attr)
+		}
+		parts = append(parts, "SORT "+strings.Join(fields, ", "))
 	}
 
-	result += " RETURN d"
+	if paging != nil && (paging.Size > 0 || paging.Offset > 0) {
+		count := paging.Size
+		if count <= 0 {
+			count = arangodbMaxCount
+		}
 
-	return result, nil
+		if err := bind("offset", paging.Offset); err != nil {
+			return "", nil, err
+		}
+		if err := bind("count", count); err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, "LIMIT @offset, @count")
+	}
+
+	parts = append(parts, "RETURN d")
+
+	return strings.Join(parts, " "), bv.vars, nil
+}
+
+// buildAggregateQuery creates the AQL query for aggregating the documents of a collection. The group fields and the
+// metric names are passed as bind variables as well. The aggregation requires at least one metric.
+func (c ArangodbClient) buildAggregateQuery(collection string, q ParameterizedQuery, agg Aggregation) (string, map[string]any, error) {
+	bv := newArangodbBindVars(q)
+	if err := bv.bind("@collection", collection); err != nil {
+		return "", nil, err
+	}
+
+	attribute := func(prefix string, i int, path []string) (string, error) {
+		attr := "d"
+		for j, p := range path {
+			name := fmt.Sprintf("%s%d_%d", prefix, i, j)
+			if err := bv.bind(name, p); err != nil {
+				return "", err
+			}
+			attr += ".@" + name
+		}
+		return attr, nil
+	}
+
+	parts := []string{"FOR d IN @@collection"}
+
+	if len(q.Query) > 0 {
+		parts = append(parts, fmt.Sprintf("FILTER %s", q.Query))
+	}
+
+	var groups, aggregates, fields []string
+	for i, path := range agg.GroupBy {
+		attr, err := attribute("g", i, path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		name := fmt.Sprintf("gn%d", i)
+		if err := bv.bind(name, strings.Join(path, ".")); err != nil {
+			return "", nil, err
+		}
+
+		groups = append(groups, fmt.Sprintf("g%d = %s", i, attr))
+		fields = append(fields, fmt.Sprintf("[@%s]: g%d", name, i))
+	}
+
+	for i, m := range agg.Metrics {
+		name := fmt.Sprintf("mn%d", i)
+		if err := bv.bind(name, m.Name); err != nil {
+			return "", nil, err
+		}
+		fields = append(fields, fmt.Sprintf("[@%s]: m%d", name, i))
+
+		if m.Func == MetricCount {
+			aggregates = append(aggregates, fmt.Sprintf("m%d = COUNT(1)", i))
+			continue
+		}
+
+		attr, err := attribute("m", i, m.Path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// -- only numbers are taken into account
+		value := fmt.Sprintf("IS_NUMBER(%s) ? %s : null", attr, attr)
+		fn := map[string]string{MetricSum: "SUM", MetricAvg: "AVERAGE", MetricMin: "MIN", MetricMax: "MAX"}[m.Func]
+		aggregates = append(aggregates, fmt.Sprintf("m%d = %s(%s)", i, fn, value))
+	}
+
+	collect := "COLLECT"
+	if len(groups) > 0 {
+		collect += " " + strings.Join(groups, ", ")
+	}
+	if len(aggregates) > 0 {
+		collect += " AGGREGATE " + strings.Join(aggregates, ", ")
+	}
+	parts = append(parts, collect)
+	parts = append(parts, "RETURN {"+strings.Join(fields, ", ")+"}")
+
+	return strings.Join(parts, " "), bv.vars, nil
+}
+
+// arangodbBindVars holds the bind variables of a query, starting from the parameters of the user provided query.
+type arangodbBindVars struct {
+	vars map[string]any
+}
+
+func newArangodbBindVars(q ParameterizedQuery) *arangodbBindVars {
+	result := &arangodbBindVars{vars: map[string]any{}}
+	for k, v := range q.Params {
+		result.vars[k] = v
+	}
+
+	return result
+}
+
+// bind adds a bind variable, failing if the name is already taken by a parameter of the user provided query.
+func (b *arangodbBindVars) bind(name string, value any) error {
+	if _, fnd := b.vars[name]; fnd {
+		return fmt.Errorf("bind parameter %q is reserved", name)
+	}
+	b.vars[name] = value
+	return nil
+}
+
+// buildFilter compiles a structured filter into an AQL filter expression. Both the attribute names and the values are
+// passed as bind parameters.
+func (c ArangodbClient) buildFilter(flt *Filter) ParameterizedQuery {
+	result := ParameterizedQuery{Params: map[string]any{}}
+	if flt == nil {
+		return result
+	}
+
+	counter := 0
+	result.Query = c.buildCondition(flt, result.Params, &counter)
+
+	return result
+}
+
+var arangodbComparisons = map[string]string{
+	FilterEq:  "==",
+	FilterNe:  "!=",
+	FilterLt:  "<",
+	FilterLte: "<=",
+	FilterGt:  ">",
+	FilterGte: ">=",
+}
+
+func (c ArangodbClient) buildCondition(flt *Filter, params map[string]any, counter *int) string {
+	switch flt.Op {
+	case FilterAnd, FilterOr, FilterNot:
+		var conditions []string
+		for _, sub := range flt.Filters {
+			cond := c.buildCondition(sub, params, counter)
+			if sub.Op == FilterAnd || sub.Op == FilterOr {
+				cond = "(" + cond + ")"
+			}
+			conditions = append(conditions, cond)
+		}
+
+		switch {
+		case flt.Op == FilterNot && len(conditions) == 0:
+			return "false"
+		case flt.Op == FilterNot:
+			return fmt.Sprintf("NOT (%s)", strings.Join(conditions, " OR "))
+		case len(conditions) == 0:
+			return strconv.FormatBool(flt.Op == FilterAnd)
+		case flt.Op == FilterOr:
+			return strings.Join(conditions, " OR ")
+		default:
+			return strings.Join(conditions, " AND ")
+		}
+	}
+
+	i := *counter
+	*counter++
+
+	attr := "d"
+	for j, p := range flt.Path {
+		name := fmt.Sprintf("f%d_%d", i, j)
+		params[name] = p
+		attr += ".@" + name
+	}
+
+	if flt.Op == FilterExists {
+		if b, _ := flt.Value.(bool); b {
+			return fmt.Sprintf("%s != null", attr)
+		}
+		return fmt.Sprintf("%s == null", attr)
+	}
+
+	// -- arangodb rejects bind parameters which are not used by the query
+	value := fmt.Sprintf("v%d", i)
+	params[value] = flt.Value
+
+	switch flt.Op {
+	case FilterIn:
+		return fmt.Sprintf("%s IN @%s", attr, value)
+	case FilterEq, FilterNe:
+		return fmt.Sprintf("%s %s @%s", attr, arangodbComparisons[flt.Op], value)
+	default:
+		// -- AQL compares values of different types by type order, so only compare values of the same type
+		return fmt.Sprintf("(TYPENAME(%s) == TYPENAME(@%s) AND %s %s @%s)", attr, value, attr, arangodbComparisons[flt.Op], value)
+	}
 }
 
 type arangodbCursorWrapper struct {
@@ -212,6 +1026,235 @@ func (c *arangodbCursorWrapper) HasNext() bool {
 
 func (c *arangodbCursorWrapper) Read() (map[string]any, error) {
 	var target map[string]any
-	_, err := c.c.ReadDocument(c.ctx, target)
+	_, err := c.c.ReadDocument(c.ctx, &target)
 	return target, err
 }
+
+// Markers of the write-ahead log of arangodb.
+const (
+	arangodbMarkerDocument = 2300
+	arangodbMarkerRemove   = 2302
+	arangodbMarkerCommit   = 2201
+	arangodbMarkerAbort    = 2202
+)
+
+// Changes tails the write-ahead log of the database for the writes to the collection. Checkpoints are ticks of the log,
+// never within a transaction which is still open, so resuming from a checkpoint may read some changes again. The log neither tells creates from updates nor holds previous documents, so every write is reported as an update.
+func (c ArangodbClient) Changes(ctx context.Context, collection string, opts ChangeOpts) (ChangeFeed, error) {
+	col, err := c.collection(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	props, err := col.Properties(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection properties: %w", err)
+	}
+
+	feed := &arangodbChangeFeed{
+		conn:         c.cl.Connection(),
+		database:     c.db.name,
+		collection:   collection,
+		cuid:         props.GloballyUniqueId,
+		from:         opts.Checkpoint,
+		pollInterval: opts.PollInterval,
+		batchSize:    opts.BatchSize,
+		transactions: map[string][]Change{},
+	}
+
+	switch {
+	case feed.from != "":
+	case opts.FromStart:
+		feed.from = "0"
+	default:
+		if feed.from, err = feed.lastTick(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return feed, nil
+}
+
+type arangodbChangeFeed struct {
+	conn         driver.Connection
+	database     string
+	collection   string
+	cuid         string
+	pollInterval time.Duration
+	batchSize    int
+
+	// from is the tick to continue tailing the log after
+	from string
+
+	// transactions holds the changes of transactions which have not been committed yet, by transaction id, each change
+	// holding the tick it was read at as its checkpoint until the transaction is committed
+	transactions map[string][]Change
+
+	// buffer holds the changes which have been read but not returned yet
+	buffer []Change
+}
+
+type arangodbLogEntry struct {
+	Tick string         `json:"tick"`
+	Type int            `json:"type"`
+	Cuid string         `json:"cuid"`
+	Tid  string         `json:"tid"`
+	Data map[string]any `json:"data"`
+}
+
+func (f *arangodbChangeFeed) lastTick(ctx context.Context) (string, error) {
+	req, err := f.conn.NewRequest("GET", path.Join("_db", f.database, "_api/wal/lastTick"))
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := f.conn.Do(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the last tick: %w", err)
+	}
+
+	if err := resp.CheckStatus(nethttp.StatusOK); err != nil {
+		return "", fmt.Errorf("failed to get the last tick: %w", err)
+	}
+
+	var tick string
+	if err := resp.ParseBody("tick", &tick); err != nil {
+		return "", fmt.Errorf("failed to parse the last tick: %w", err)
+	}
+
+	return tick, nil
+}
+
+func (f *arangodbChangeFeed) Next(ctx context.Context) ([]Change, error) {
+	for len(f.buffer) == 0 {
+		more, err := f.tail(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(f.buffer) > 0 || more {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(f.pollInterval):
+		}
+	}
+
+	n := len(f.buffer)
+	if f.batchSize > 0 && n > f.batchSize {
+		// -- the changes of a transaction share their checkpoint, so they can not be split
+		n = f.batchSize
+		for n < len(f.buffer) && f.buffer[n].Checkpoint == f.buffer[n-1].Checkpoint {
+			n++
+		}
+	}
+
+	result := f.buffer[:n]
+	f.buffer = f.buffer[n:]
+	return result, nil
+}
+
+// tail reads the next chunk of the log, returning whether there is more to read right away.
+func (f *arangodbChangeFeed) tail(ctx context.Context) (bool, error) {
+	req, err := f.conn.NewRequest("GET", path.Join("_db", f.database, "_api/wal/tail"))
+	if err != nil {
+		return false, err
+	}
+	req.SetQuery("from", f.from)
+
+	var raw []byte
+	resp, err := f.conn.Do(driver.WithRawResponse(ctx, &raw), req)
+	if err != nil {
+		return false, fmt.Errorf("failed to tail the log: %w", err)
+	}
+
+	if err := resp.CheckStatus(nethttp.StatusOK, nethttp.StatusNoContent); err != nil {
+		return false, fmt.Errorf("failed to tail the log: %w", err)
+	}
+
+	if err := f.consume(raw); err != nil {
+		return false, err
+	}
+
+	// -- continue after the last entry returned, or after the part of the log which was scanned if there were none
+	next := resp.Header("X-Arango-Replication-Lastincluded")
+	if next == "" || next == "0" {
+		next = resp.Header("X-Arango-Replication-Lastscanned")
+	}
+	if next != "" && next != "0" {
+		f.from = next
+	}
+
+	return resp.Header("X-Arango-Replication-Checkmore") == "true", nil
+}
+
+// consume turns the newline delimited entries of the log into changes to the collection. Changes made within a
+// transaction are held back until the transaction is committed, taking the tick of the commit as their checkpoint.
+func (f *arangodbChangeFeed) consume(raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for {
+		var entry arangodbLogEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to parse log entry: %w", err)
+		}
+
+		switch entry.Type {
+		case arangodbMarkerDocument, arangodbMarkerRemove:
+			if entry.Cuid != f.cuid {
+				continue
+			}
+
+			key, _ := entry.Data["_key"].(string)
+			change := Change{Operation: ChangeUpdate, Collection: f.collection, Key: key, After: entry.Data, Checkpoint: entry.Tick}
+			if entry.Type == arangodbMarkerRemove {
+				change.Operation, change.After = ChangeDelete, nil
+			}
+
+			if entry.Tid == "" || entry.Tid == "0" {
+				change.Checkpoint = f.checkpoint(entry.Tick)
+				f.buffer = append(f.buffer, change)
+			} else {
+				f.transactions[entry.Tid] = append(f.transactions[entry.Tid], change)
+			}
+		case arangodbMarkerCommit:
+			committed := f.transactions[entry.Tid]
+			delete(f.transactions, entry.Tid)
+
+			checkpoint := f.checkpoint(entry.Tick)
+			for _, change := range committed {
+				change.Checkpoint = checkpoint
+				f.buffer = append(f.buffer, change)
+			}
+		case arangodbMarkerAbort:
+			delete(f.transactions, entry.Tid)
+		}
+	}
+}
+
+// checkpoint returns the checkpoint of the changes read at the tick. While transactions holding changes to the
+// collection are open, this is the tick before the first change of the oldest one instead, so resuming from the
+// checkpoint reads those transactions again.
+func (f *arangodbChangeFeed) checkpoint(tick string) string {
+	result, err := strconv.ParseUint(tick, 10, 64)
+	if err != nil {
+		return tick
+	}
+
+	for _, changes := range f.transactions {
+		first, err := strconv.ParseUint(changes[0].Checkpoint, 10, 64)
+		if err == nil && first-1 < result {
+			result = first - 1
+		}
+	}
+
+	return strconv.FormatUint(result, 10)
+}
+
+func (f *arangodbChangeFeed) Close() error {
+	return nil
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	driver "github.com/arangodb/go-driver"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_ArangodbClient__shouldBuildAuthentication(t *testing.T) {
+	spec := service.NewConfigSpec().Fields(ArangodbConfigFields()...)
+
+	for name, tc := range map[string]struct {
+		yaml     string
+		typ      driver.AuthenticationType
+		property string
+		value    string
+	}{
+		"basic": {"", driver.AuthenticationTypeBasic, "username", "root"},
+		"jwt":   {"authentication: jwt", driver.AuthenticationTypeJWT, "password", "secret"},
+		"token": {"authentication: jwt\ntoken: abc", driver.AuthenticationTypeRaw, "value", "bearer abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conf, err := spec.ParseYAML("urls: [http://localhost:8529]\ndatabase: test\n"+tc.yaml, nil)
+			require.NoError(t, err)
+
+			auth, err := arangodbAuthenticationFromConfig(conf, "root", "secret")
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.typ, auth.Type())
+			assert.Equal(t, tc.value, auth.Get(tc.property))
+		})
+	}
+}
+
+func Test_ArangodbClient__shouldBuildQuery(t *testing.T) {
+	cl := ArangodbClient{}
+
+	qry, bindVars, err := cl.buildQuery("orders", ParameterizedQuery{
+		Query:  "d.status == @status",
+		Params: map[string]any{"status": "open"},
+	}, &PagingOpts{Offset: 20, Size: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, "FOR d IN @@collection FILTER d.status == @status LIMIT @offset, @count RETURN d", qry)
+	assert.Equal(t, map[string]any{
+		"@collection": "orders",
+		"status":      "open",
+		"offset":      int64(20),
+		"count":       int64(10),
+	}, bindVars)
+}
+
+func Test_ArangodbClient__shouldBuildSortedQuery(t *testing.T) {
+	cl := ArangodbClient{}
+
+	qry, bindVars, err := cl.buildQuery("orders", ParameterizedQuery{}, &PagingOpts{
+		Offset: 5,
+		Sort:   []SortField{{Path: []string{"customer", "name"}}, {Path: []string{"total"}, Descending: true}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "FOR d IN @@collection SORT d.@s0_0.@s0_1 ASC, d.@s1_0 DESC LIMIT @offset, @count RETURN d", qry)
+	assert.Equal(t, map[string]any{
+		"@collection": "orders",
+		"s0_0":        "customer",
+		"s0_1":        "name",
+		"s1_0":        "total",
+		"offset":      int64(5),
+		"count":       int64(arangodbMaxCount),
+	}, bindVars)
+}
+
+func Test_ArangodbClient__shouldRejectReservedBindVars(t *testing.T) {
+	cl := ArangodbClient{}
+
+	_, _, err := cl.buildQuery("orders", ParameterizedQuery{
+		Query:  "d.total > @offset",
+		Params: map[string]any{"offset": 10},
+	}, &PagingOpts{Offset: 20, Size: 10})
+	assert.Error(t, err)
+}
+
+func Test_ArangodbClient__shouldBuildFilter(t *testing.T) {
+	cl := ArangodbClient{}
+
+	flt, err := parseFilter(`customer.name == "alpha" AND total >= 10`)
+	require.NoError(t, err)
+
+	qry := cl.buildFilter(flt)
+
+	assert.Equal(t, "d.@f0_0.@f0_1 == @v0 AND (TYPENAME(d.@f1_0) == TYPENAME(@v1) AND d.@f1_0 >= @v1)", qry.Query)
+	assert.Equal(t, map[string]any{
+		"f0_0": "customer",
+		"f0_1": "name",
+		"v0":   "alpha",
+		"f1_0": "total",
+		"v1":   float64(10),
+	}, qry.Params)
+}
+
+func Test_ArangodbClient__shouldBuildNestedFilter(t *testing.T) {
+	cl := ArangodbClient{}
+
+	flt, err := ParseFilterDocument(map[string]any{
+		"status": map[string]any{"$in": []any{"open", "pending"}},
+		"$or": []any{
+			map[string]any{"owner": map[string]any{"$exists": false}},
+			map[string]any{"$not": map[string]any{"priority": "low"}},
+		},
+	})
+	require.NoError(t, err)
+
+	qry := cl.buildFilter(flt)
+
+	assert.Equal(t, "(d.@f0_0 == null OR NOT (d.@f1_0 == @v1)) AND d.@f2_0 IN @v2", qry.Query)
+	assert.Equal(t, map[string]any{
+		"f0_0": "owner",
+		"f1_0": "priority",
+		"v1":   "low",
+		"f2_0": "status",
+		"v2":   []any{"open", "pending"},
+	}, qry.Params)
+}
+
+func Test_ArangodbClient__shouldBuildAggregateQuery(t *testing.T) {
+	cl := ArangodbClient{}
+
+	qry, bindVars, err := cl.buildAggregateQuery("orders", ParameterizedQuery{
+		Query:  "d.status == @status",
+		Params: map[string]any{"status": "open"},
+	}, Aggregation{
+		GroupBy: [][]string{{"customer", "id"}},
+		Metrics: []Metric{
+			{Name: "orders", Func: MetricCount},
+			{Name: "revenue", Func: MetricSum, Path: []string{"total"}},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "FOR d IN @@collection FILTER d.status == @status"+
+		" COLLECT g0 = d.@g0_0.@g0_1 AGGREGATE m0 = COUNT(1), m1 = SUM(IS_NUMBER(d.@m1_0) ? d.@m1_0 : null)"+
+		" RETURN {[@gn0]: g0, [@mn0]: m0, [@mn1]: m1}", qry)
+	assert.Equal(t, map[string]any{
+		"@collection": "orders",
+		"status":      "open",
+		"g0_0":        "customer",
+		"g0_1":        "id",
+		"gn0":         "customer.id",
+		"mn0":         "orders",
+		"mn1":         "revenue",
+		"m1_0":        "total",
+	}, bindVars)
+}
+
+func Test_ArangodbClient__shouldBuildTraversal(t *testing.T) {
+	cl := ArangodbClient{}
+
+	start, err := NewVertex("CON#crm#customer", "", "42")
+	require.NoError(t, err)
+
+	qry, bindVars, err := cl.buildTraversal("relations", start, TraversalOpts{Direction: DirectionAny, MinDepth: 1, MaxDepth: 3})
+	require.NoError(t, err)
+
+	assert.Equal(t, `FOR v, e, p IN 1..3 ANY @start @@collection OPTIONS {order: "bfs", uniqueVertices: "global"} RETURN {vertex: v, edge: e, depth: LENGTH(p.edges)}`, qry)
+	assert.Equal(t, map[string]any{
+		"@collection": "relations",
+		"start":       "crm_customer/42",
+	}, bindVars)
+}
+
+func Test_ArangodbClient__shouldRejectInvalidTraversal(t *testing.T) {
+	cl := ArangodbClient{}
+	start := Vertex{Key: "42"}
+
+	_, _, err := cl.buildTraversal("relations", start, TraversalOpts{Direction: "sideways", MaxDepth: 1})
+	assert.Error(t, err)
+
+	_, _, err = cl.buildTraversal("relations", start, TraversalOpts{MinDepth: 2, MaxDepth: 1})
+	assert.Error(t, err)
+}
+
+func Test_ArangodbChangeFeed__shouldConsumeLog(t *testing.T) {
+	feed := &arangodbChangeFeed{collection: "orders", cuid: "h1", transactions: map[string][]Change{}}
+
+	require.NoError(t, feed.consume([]byte(`{"tick":"10","type":2300,"cuid":"h1","tid":"0","data":{"_key":"a","total":1}}
+{"tick":"11","type":2300,"cuid":"h2","tid":"0","data":{"_key":"x"}}
+{"tick":"12","type":2200,"tid":"7"}
+{"tick":"13","type":2302,"cuid":"h1","tid":"7","data":{"_key":"a"}}
+{"tick":"14","type":2200,"tid":"8"}
+{"tick":"15","type":2300,"cuid":"h1","tid":"8","data":{"_key":"b"}}
+{"tick":"16","type":2202,"tid":"8"}
+`)))
+
+	// -- the transaction is not committed yet
+	assert.Equal(t, []Change{
+		{Operation: ChangeUpdate, Collection: "orders", Key: "a", After: map[string]any{"_key": "a", "total": float64(1)}, Checkpoint: "10"},
+	}, feed.buffer)
+
+	require.NoError(t, feed.consume([]byte(`{"tick":"17","type":2201,"tid":"7"}`)))
+	assert.Equal(t, Change{Operation: ChangeDelete, Collection: "orders", Key: "a", Checkpoint: "17"}, feed.buffer[1])
+	assert.Len(t, feed.buffer, 2)
+	assert.Empty(t, feed.transactions)
+}
+
+// newTestArangodbClient returns a client of a fake arangodb server, which handles requests on documents using the
+// given handler and answers any other request with an empty object.
+func newTestArangodbClient(t *testing.T, documents http.HandlerFunc) ArangodbClient {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(r.URL.Path, "/_api/document/"):
+			documents(w, r)
+		case strings.Contains(r.URL.Path, "/_api/collection/"):
+			_, _ = w.Write([]byte(`{"name":"` + path.Base(r.URL.Path) + `","type":2}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conf, err := service.NewConfigSpec().Fields(ArangodbConfigFields()...).ParseYAML("urls: ["+srv.URL+"]\ndatabase: test", nil)
+	require.NoError(t, err)
+
+	cl, err := NewArangodbClientFromConfig(conf, service.MockResources())
+	require.NoError(t, err)
+
+	return cl.(ArangodbClient)
+}
+
+func Test_ArangodbClient__shouldReportExistingKeyOnAdd(t *testing.T) {
+	cl := newTestArangodbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":true,"code":409,"errorNum":1210,"errorMessage":"unique constraint violated"}`))
+	})
+
+	err := cl.Add(context.Background(), "concepts", "a", map[string]any{"name": "alpha"})
+	assert.ErrorIs(t, err, service.ErrKeyAlreadyExists)
+}
+
+func Test_ArangodbClient__shouldReplaceWithinSingleRequest(t *testing.T) {
+	var requests []string
+	var body map[string]any
+	cl := newTestArangodbClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Query().Get("overwriteMode"))
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"_id":"concepts/a","_key":"a","_rev":"_rev1"}`))
+	})
+
+	value := map[string]any{"name": "alpha"}
+	rev, err := cl.SetRevision(context.Background(), "concepts", "a", value, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, "_rev1", rev)
+	assert.Equal(t, []string{"POST replace"}, requests)
+	assert.Equal(t, map[string]any{"_key": "a", "name": "alpha"}, body)
+
+	// -- the value of the caller, being the payload of a message, is left untouched
+	assert.Equal(t, map[string]any{"name": "alpha"}, value)
+}
+
+func Test_ArangodbChangeFeed__shouldNotCheckpointWithinOpenTransactions(t *testing.T) {
+	feed := &arangodbChangeFeed{collection: "orders", cuid: "h1", transactions: map[string][]Change{}}
+
+	require.NoError(t, feed.consume([]byte(`{"tick":"10","type":2300,"cuid":"h1","tid":"0","data":{"_key":"a"}}
+{"tick":"12","type":2200,"tid":"7"}
+{"tick":"13","type":2300,"cuid":"h1","tid":"7","data":{"_key":"b"}}
+{"tick":"15","type":2300,"cuid":"h1","tid":"0","data":{"_key":"c"}}
+{"tick":"17","type":2201,"tid":"7"}
+{"tick":"18","type":2300,"cuid":"h1","tid":"0","data":{"_key":"d"}}
+`)))
+
+	var checkpoints []string
+	for _, change := range feed.buffer {
+		checkpoints = append(checkpoints, change.Key+"@"+change.Checkpoint)
+	}
+
+	// -- resuming after c must read the transaction holding b again
+	assert.Equal(t, []string{"a@10", "c@12", "b@17", "d@18"}, checkpoints)
+}
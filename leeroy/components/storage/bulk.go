@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// BulkClient is implemented by clients able to apply multiple write operations in a single round trip to the store.
+type BulkClient interface {
+	// Bulk applies the operations, returning an error per operation which is nil if the operation succeeded. The
+	// returned error is only set if none of the operations could be applied.
+	Bulk(ctx context.Context, ops []BulkOperation) ([]error, error)
+}
+
+type BulkOperation struct {
+	// Operation is one of 'set', 'add' or 'delete'
+	Operation  string
+	Collection string
+	Key        string
+	Value      map[string]any
+}
+
+// IsBulkOperation returns whether the operation can be applied as part of a bulk request.
+func IsBulkOperation(op string) bool {
+	switch op {
+	case "set", "add", "delete":
+		return true
+	default:
+		return false
+	}
+}
+
+// Bulk applies the operations using the bulk support of the client if available, or one by one if not.
+func Bulk(ctx context.Context, cl Client, ops []BulkOperation) ([]error, error) {
+	if bc, ok := cl.(BulkClient); ok {
+		errs, err := bc.Bulk(ctx, ops)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(errs) != len(ops) {
+			return nil, fmt.Errorf("expected %d bulk results, got %d", len(ops), len(errs))
+		}
+
+		return errs, nil
+	}
+
+	errs := make([]error, len(ops))
+	for i, op := range ops {
+		switch op.Operation {
+		case "set":
+			errs[i] = cl.Set(ctx, op.Collection, op.Key, op.Value)
+		case "add":
+			errs[i] = cl.Add(ctx, op.Collection, op.Key, op.Value)
+		case "delete":
+			errs[i] = cl.Delete(ctx, op.Collection, op.Key)
+		default:
+			errs[i] = fmt.Errorf("operation %q is not supported in bulk", op.Operation)
+		}
+	}
+
+	return errs, nil
+}
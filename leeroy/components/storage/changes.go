@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Operations of a change.
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ChangeClient is implemented by clients able to tail the changes made to the documents of a collection.
+type ChangeClient interface {
+	// Changes opens a feed of the changes made to the collection, starting after the checkpoint of the options.
+	Changes(ctx context.Context, collection string, opts ChangeOpts) (ChangeFeed, error)
+}
+
+type ChangeOpts struct {
+	// Checkpoint is the checkpoint of the change to resume after. Without a checkpoint, the feed starts with the changes
+	// made from now on, or with all changes still known to the store if FromStart is set.
+	Checkpoint string
+	FromStart  bool
+
+	// PollInterval is how long to wait before asking the store for changes again, for drivers polling for changes.
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of changes returned at once.
+	BatchSize int
+}
+
+// ChangeFeed is a feed of the changes made to a collection, in the order they were made.
+type ChangeFeed interface {
+	// Next waits for changes, returning at most the batch size of changes at once.
+	Next(ctx context.Context) ([]Change, error)
+	Close() error
+}
+
+// Change describes a change made to a document. The document before the change is only known for drivers keeping
+// track of it, the document after the change is missing for deletes.
+type Change struct {
+	Operation  string
+	Collection string
+	Key        string
+	Before     map[string]any
+	After      map[string]any
+
+	// Checkpoint identifies the position of the change within the feed. It is opaque and specific to the driver.
+	Checkpoint string
+}
+
+// checkpoints keeps track of the checkpoints of batches which are still being processed. Batches may be acknowledged
+// out of order, so a checkpoint is only committed once all batches before it have been acknowledged.
+type checkpoints struct {
+	mu      sync.Mutex
+	pending []*pendingCheckpoint
+}
+
+type pendingCheckpoint struct {
+	checkpoint string
+	acked      bool
+}
+
+// add registers the checkpoint of a batch which is being processed.
+func (c *checkpoints) add(checkpoint string) *pendingCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := &pendingCheckpoint{checkpoint: checkpoint}
+	c.pending = append(c.pending, result)
+	return result
+}
+
+// ack marks the batch as processed, returning the checkpoint which can be committed or an empty string if there is
+// none.
+func (c *checkpoints) ack(p *pendingCheckpoint) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p.acked = true
+
+	var result string
+	for len(c.pending) > 0 && c.pending[0].acked {
+		result = c.pending[0].checkpoint
+		c.pending = c.pending[1:]
+	}
+
+	return result
+}
@@ -1,8 +1,25 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"strings"
+)
 
+// ErrNotFound is returned by clients when a document, or the collection holding it, does not exist. It matches
+// service.ErrKeyNotFound as well.
+var ErrNotFound = fmt.Errorf("document not found: %w", service.ErrKeyNotFound)
+
+// Client is implemented by the storage drivers. Reading, deleting or conditionally writing a document which does not
+// exist results in ErrNotFound, adding a document which already exists results in service.ErrKeyAlreadyExists.
+//
+// Drivers connect lazily and reconnect by themselves, so creating a client succeeds while the store is unavailable. Use
+// Ping to find out whether the store can be reached.
 type Client interface {
+	// Ping checks whether the store can be reached, returning the reason if it can not.
+	Ping(ctx context.Context) error
+
 	ParseQuery(config string) (any, error)
 	List(ctx context.Context, collection string, q any, pitEnabled bool, paging *PagingOpts) (Cursor, error)
 	Get(ctx context.Context, collection string, key string) (map[string]any, error)
@@ -16,6 +33,50 @@ type Client interface {
 type PagingOpts struct {
 	Offset int64
 	Size   int64
+
+	// PageSize is the number of documents fetched per round trip by drivers reading results in pages.
+	PageSize int64
+
+	// Sort holds the fields to order the results by, in order of precedence.
+	Sort []SortField
+
+	// KeepAlive is how long a point in time is kept alive between fetching two pages.
+	KeepAlive string
+}
+
+type SortField struct {
+	Path       []string
+	Descending bool
+}
+
+// ParseSort parses a comma separated list of dotted field paths, each optionally followed by `:asc` or `:desc`.
+func ParseSort(s string) ([]SortField, error) {
+	var result []SortField
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field, order, _ := strings.Cut(part, ":")
+
+		sf := SortField{Path: strings.Split(strings.TrimSpace(field), ".")}
+		switch strings.ToLower(strings.TrimSpace(order)) {
+		case "", "asc":
+		case "desc":
+			sf.Descending = true
+		default:
+			return nil, fmt.Errorf("invalid sort order %q for field %q", order, field)
+		}
+
+		result = append(result, sf)
+	}
+
+	return result, nil
+}
+
+func (s SortField) Field() string {
+	return strings.Join(s.Path, ".")
 }
 
 type Cursor interface {
@@ -24,6 +85,68 @@ type Cursor interface {
 	Close() error
 }
 
+// limitCursor applies an offset and a limit to a cursor for drivers unable to do so natively.
+type limitCursor struct {
+	Cursor
+	skip      int64
+	remaining int64
+	err       error
+}
+
+// newLimitCursor skips the first offset documents of the cursor and returns at most size documents, or all remaining
+// documents if size is 0.
+func newLimitCursor(c Cursor, offset int64, size int64) Cursor {
+	if offset <= 0 && size <= 0 {
+		return c
+	}
+
+	remaining := size
+	if remaining <= 0 {
+		remaining = -1
+	}
+
+	return &limitCursor{Cursor: c, skip: offset, remaining: remaining}
+}
+
+func (c *limitCursor) HasNext() bool {
+	for c.skip > 0 && c.err == nil && c.Cursor.HasNext() {
+		// -- keep the error to surface it on the next read
+		_, c.err = c.Cursor.Read()
+		c.skip--
+	}
+
+	if c.err != nil {
+		return true
+	}
+
+	return c.remaining != 0 && c.Cursor.HasNext()
+}
+
+func (c *limitCursor) Read() (map[string]any, error) {
+	if !c.HasNext() {
+		return nil, fmt.Errorf("no more documents")
+	}
+
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		return nil, err
+	}
+
+	if c.remaining > 0 {
+		c.remaining--
+	}
+
+	return c.Cursor.Read()
+}
+
+// ParameterizedQuery is a native query of which the parameters are bound separately from the query text, preventing
+// message content from altering the query itself.
+type ParameterizedQuery struct {
+	Query  string
+	Params map[string]any
+}
+
 type Query interface {
 	Parse(config string) error
 }
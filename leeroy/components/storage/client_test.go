@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_ParseSort(t *testing.T) {
+	res, err := ParseSort("created_at:desc, customer.name ,total:ASC")
+	require.NoError(t, err)
+	assert.Equal(t, []SortField{
+		{Path: []string{"created_at"}, Descending: true},
+		{Path: []string{"customer", "name"}},
+		{Path: []string{"total"}},
+	}, res)
+
+	_, err = ParseSort("total:up")
+	assert.Error(t, err)
+}
+
+func Test_LimitCursor(t *testing.T) {
+	newCursor := func() Cursor {
+		return &memoryCursor{docs: []map[string]any{{"i": 0}, {"i": 1}, {"i": 2}, {"i": 3}}}
+	}
+
+	read := func(c Cursor) []any {
+		var result []any
+		for c.HasNext() {
+			doc, err := c.Read()
+			require.NoError(t, err)
+			result = append(result, doc["i"])
+		}
+		return result
+	}
+
+	assert.Equal(t, []any{0, 1, 2, 3}, read(newLimitCursor(newCursor(), 0, 0)))
+	assert.Equal(t, []any{1, 2}, read(newLimitCursor(newCursor(), 1, 2)))
+	assert.Equal(t, []any{2, 3}, read(newLimitCursor(newCursor(), 2, 0)))
+	assert.Equal(t, []any(nil), read(newLimitCursor(newCursor(), 5, 2)))
+}
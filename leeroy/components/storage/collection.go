@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"strings"
+)
+
+// CollectionClient is implemented by clients requiring collections to be created before documents can be stored in
+// them.
+type CollectionClient interface {
+	// EnsureCollection creates the collection if it does not exist yet and makes sure the indexes of the spec exist. An
+	// existing collection is left as is otherwise.
+	EnsureCollection(ctx context.Context, collection string, spec CollectionSpec) error
+}
+
+// CollectionSpec describes how a collection is to be created. Drivers only take the parts they support into account.
+type CollectionSpec struct {
+	// Mappings holds the field mappings of an elasticsearch index.
+	Mappings map[string]any
+
+	// Settings holds the settings of an elasticsearch index.
+	Settings map[string]any
+
+	// Indexes holds the secondary indexes to create, for arangodb collections and postgres tables.
+	Indexes []IndexSpec
+
+	// Edges creates a collection holding the edges of a graph, for arangodb.
+	Edges bool
+}
+
+type IndexSpec struct {
+	// Name is the name of the index, generated from the collection and the fields if empty.
+	Name   string
+	Fields []string
+	Unique bool
+
+	// Sparse indexes skip documents not holding the indexed fields.
+	Sparse bool
+}
+
+// IndexName returns the name of the index, generating one from the collection and the fields if it has none.
+func (i IndexSpec) IndexName(collection string) string {
+	if i.Name != "" {
+		return i.Name
+	}
+
+	parts := []string{collection}
+	for _, f := range i.Fields {
+		parts = append(parts, strings.ReplaceAll(f, ".", "_"))
+	}
+
+	return strings.Join(append(parts, "idx"), "_")
+}
+
+// EnsureCollection creates the collection if the client requires collections to be created. Clients creating
+// collections on the fly are left alone.
+func EnsureCollection(ctx context.Context, cl Client, collection string, spec CollectionSpec) error {
+	if cc, ok := cl.(CollectionClient); ok {
+		return cc.EnsureCollection(ctx, collection, spec)
+	}
+
+	return nil
+}
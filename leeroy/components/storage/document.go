@@ -0,0 +1,43 @@
+package storage
+
+// mergeDocuments deep merges src into dst, returning dst.
+func mergeDocuments(dst, src map[string]any) map[string]any {
+	for k, v := range src {
+		sm, srcIsMap := v.(map[string]any)
+		dm, dstIsMap := dst[k].(map[string]any)
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeDocuments(dm, sm)
+		} else {
+			dst[k] = v
+		}
+	}
+
+	return dst
+}
+
+func copyDocument(doc map[string]any) map[string]any {
+	if doc == nil {
+		return nil
+	}
+
+	return copyValue(doc).(map[string]any)
+}
+
+func copyValue(v any) any {
+	switch vt := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(vt))
+		for k, e := range vt {
+			result[k] = copyValue(e)
+		}
+		return result
+	case []any:
+		result := make([]any, len(vt))
+		for i, e := range vt {
+			result[i] = copyValue(e)
+		}
+		return result
+	default:
+		return v
+	}
+}
@@ -4,232 +4,673 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/conflicts"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/optype"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/result"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+	"github.com/shono-io/leeroy/leeroy/components/elasticsearch/connection"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func IsElasticsearchConfigured(conf *service.ParsedConfig) bool {
-	_, err := conf.FieldStringList("elasticsearch", "addresses")
-	hasAddresses := err == nil
-
-	_, err = conf.FieldString("elasticsearch", "cloud_id")
-	hasCloudId := err == nil
+const (
+	esDefaultKeepAlive = "1m"
+	esDefaultPageSize  = 100
+)
 
-	return hasAddresses || hasCloudId
+func init() {
+	if err := RegisterDriver("elasticsearch", ElasticsearchConfigFields(), NewElasticsearchClientFromConfig); err != nil {
+		panic(err)
+	}
 }
 
 func NewElasticsearchClientFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (Client, error) {
-	cfg, err := clientConfigFromConfig(conf)
+	rp, err := connection.FieldRefresh(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	cl, err := elasticsearch.NewTypedClient(*cfg)
+	retryOnConflict, err := conf.FieldInt("retry_on_conflict")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse retry_on_conflict: %w", err)
+	}
+
+	conn, err := connection.FromConfig(conf)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create the elasticsearch client: %w", err)
+		return nil, err
 	}
 
-	return &ElasticsearchClient{cl: cl, logger: mgr.Logger()}, nil
+	return &ElasticsearchClient{conn: conn, cl: conn.Client(), refresh: rp, retryOnConflict: retryOnConflict, logger: mgr.Logger()}, nil
 }
 
 func ElasticsearchConfigFields() []*service.ConfigField {
-	return []*service.ConfigField{
-		service.NewStringListField("addresses").Description("A list of Elasticsearch addresses to connect to.").Default([]string{}),
-		service.NewStringField("username").Description("The username to use for authentication.").Default(""),
-		service.NewStringField("password").Description("The password to use for authentication.").Default(""),
-		service.NewStringField("cloud_id").Description("The cloud ID to use for authentication.").Default(""),
-		service.NewStringField("api_key").Description("The API key to use for authentication.").Default(""),
-		service.NewStringField("service_token").Description("The service token to use for authentication.").Default(""),
-		service.NewStringField("certificate_fingerprint").Description("The certificate fingerprint to use for authentication.").Default(""),
+	return append(connection.Fields(),
+		connection.RefreshField(),
+		service.NewIntField("retry_on_conflict").Description("How many times a merge is retried when the document was changed concurrently.").Default(3),
+	)
+}
+
+type ElasticsearchClient struct {
+	conn            *connection.Connection
+	cl              *elasticsearch.TypedClient
+	refresh         refresh.Refresh
+	retryOnConflict int
+	logger          *service.Logger
+}
+
+func (c *ElasticsearchClient) ParseQuery(config string) (any, error) {
+	var subQry types.Query
+	if err := json.Unmarshal([]byte(config), &subQry); err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+	return &subQry, nil
+}
+
+// List searches the index. With point in time enabled, the query is only the query part of a search request and the
+// results are read in pages of `PageSize` documents using `search_after`. Otherwise, the query is a full search request
+// of which a single page of results is returned.
+func (c *ElasticsearchClient) List(ctx context.Context, collection string, q any, pitEnabled bool, paging *PagingOpts) (Cursor, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query is nil")
+	}
+
+	if paging == nil {
+		paging = &PagingOpts{}
+	}
+
+	if flt, ok := q.(*Filter); ok {
+		compiled := esQuery(flt)
+		if !pitEnabled {
+			body := search.NewRequest()
+			body.Query = &compiled
+			return c.search(ctx, collection, body, paging)
+		}
+		q = &compiled
+	}
+
+	if pitEnabled {
+		qry, ok := q.(*types.Query)
+		if !ok {
+			return nil, fmt.Errorf("query is not a valid elasticsearch query; a pit query requires only the query part of a full search message")
+		}
+
+		keepAlive := paging.KeepAlive
+		if keepAlive == "" {
+			keepAlive = esDefaultKeepAlive
+		}
+
+		pageSize := int(paging.PageSize)
+		if pageSize <= 0 {
+			pageSize = esDefaultPageSize
+		}
+
+		resp, err := c.cl.OpenPointInTime(collection).KeepAlive(keepAlive).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pit := &types.PointInTimeReference{
+			Id:        resp.Id,
+			KeepAlive: keepAlive,
+		}
+
+		sorting := esSortOptions(paging.Sort)
+		if len(sorting) == 0 {
+			sort := types.NewSortOptions()
+			sort.Doc_ = &types.ScoreSort{Order: &sortorder.Desc}
+			sorting = append(sorting, sort)
+		}
+
+		if c.logger != nil {
+			b, _ := json.Marshal(qry)
+			c.logger.Debugf("executing %s", string(b))
+		}
+
+		req := c.cl.Search().Query(qry).Pit(pit).Sort(sorting...).Size(pageSize).TrackScores(true)
+
+		// -- search_after does not support an offset, so the cursor skips the documents instead
+		cur, err := newEsCursor(ctx, c.cl, req, pit)
+		if err != nil {
+			return nil, err
+		}
+
+		return newLimitCursor(cur, paging.Offset, paging.Size), nil
+	} else {
+		qry, ok := q.(string)
+		if !ok {
+			return nil, fmt.Errorf("a full search message is required when pits are disabled")
+		}
+
+		body := search.NewRequest()
+		if err := json.Unmarshal([]byte(qry), body); err != nil {
+			return nil, fmt.Errorf("failed to parse search message: %w", err)
+		}
+
+		return c.search(ctx, collection, body, paging)
 	}
 }
 
-func clientConfigFromConfig(conf *service.ParsedConfig) (*elasticsearch.Config, error) {
-	result := &elasticsearch.Config{
-		Addresses:              []string{},
-		Username:               "",
-		Password:               "",
-		CloudID:                "",
-		APIKey:                 "",
-		ServiceToken:           "",
-		CertificateFingerprint: "",
+// search returns a single page of results of the search request.
+func (c *ElasticsearchClient) search(ctx context.Context, collection string, body *search.Request, paging *PagingOpts) (Cursor, error) {
+	if paging.Offset > 0 {
+		from := int(paging.Offset)
+		body.From = &from
+	}
+
+	if paging.Size > 0 {
+		size := int(paging.Size)
+		body.Size = &size
+	}
+
+	if len(paging.Sort) > 0 {
+		body.Sort = esSortOptions(paging.Sort)
+	}
+
+	req := c.cl.Search().Index(collection).Request(body)
+	return newEsCursor(ctx, c.cl, req, nil)
+}
+
+// esQuery compiles a filter into an elasticsearch query. Equality is checked using term queries, so string values are
+// compared with the exact, non analyzed, value of keyword fields.
+func esQuery(flt *Filter) types.Query {
+	if flt == nil {
+		return types.Query{MatchAll: types.NewMatchAllQuery()}
+	}
+
+	switch flt.Op {
+	case FilterAnd:
+		var queries []types.Query
+		for _, sub := range flt.Filters {
+			queries = append(queries, esQuery(sub))
+		}
+		return types.Query{Bool: &types.BoolQuery{Filter: queries}}
+	case FilterOr:
+		if len(flt.Filters) == 0 {
+			return types.Query{MatchNone: types.NewMatchNoneQuery()}
+		}
+
+		var queries []types.Query
+		for _, sub := range flt.Filters {
+			queries = append(queries, esQuery(sub))
+		}
+		return types.Query{Bool: &types.BoolQuery{Should: queries, MinimumShouldMatch: 1}}
+	case FilterNot:
+		if len(flt.Filters) == 0 {
+			return types.Query{MatchNone: types.NewMatchNoneQuery()}
+		}
+
+		var queries []types.Query
+		for _, sub := range flt.Filters {
+			queries = append(queries, esQuery(sub))
+		}
+		return types.Query{Bool: &types.BoolQuery{MustNot: queries}}
+	case FilterEq:
+		return types.Query{Term: map[string]types.TermQuery{flt.Field(): {Value: flt.Value}}}
+	case FilterNe:
+		return types.Query{Bool: &types.BoolQuery{MustNot: []types.Query{
+			{Term: map[string]types.TermQuery{flt.Field(): {Value: flt.Value}}},
+		}}}
+	case FilterIn:
+		return types.Query{Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{flt.Field(): flt.Value}}}
+	case FilterExists:
+		exists := types.Query{Exists: &types.ExistsQuery{Field: flt.Field()}}
+		if b, _ := flt.Value.(bool); b {
+			return exists
+		}
+		return types.Query{Bool: &types.BoolQuery{MustNot: []types.Query{exists}}}
+	default:
+		// -- the range operators of a filter are named after the ones of elasticsearch
+		return types.Query{Range: map[string]types.RangeQuery{flt.Field(): map[string]any{flt.Op: flt.Value}}}
+	}
+}
+
+// EnsureCollection creates the index with the mappings and settings of the spec. The mappings and settings of an
+// existing index are not updated.
+func (c *ElasticsearchClient) EnsureCollection(ctx context.Context, collection string, spec CollectionSpec) error {
+	exists, err := c.cl.Indices.Exists(collection).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if index %s exists: %w", collection, err)
+	}
+
+	if exists {
+		return nil
+	}
+
+	body := map[string]any{}
+	if spec.Mappings != nil {
+		body["mappings"] = spec.Mappings
+	}
+	if spec.Settings != nil {
+		body["settings"] = spec.Settings
 	}
 
-	addresses, err := conf.FieldStringList("addresses")
+	b, err := json.Marshal(body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse addresses: %w", err)
+		return fmt.Errorf("failed to encode index definition: %w", err)
+	}
+
+	_, err = c.cl.Indices.Create(collection).Raw(bytes.NewReader(b)).Do(ctx)
+
+	// -- someone else created the index in the meantime
+	var esErr *types.ElasticsearchError
+	if errors.As(err, &esErr) && esErr.ErrorCause.Type == "resource_already_exists_exception" {
+		return nil
 	}
-	if len(addresses) > 0 {
-		result.Addresses = addresses
+
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", collection, err)
 	}
 
-	username, err := conf.FieldString("username")
+	return nil
+}
+
+// PurgeExpired deletes the documents of which the expiry time has passed using a delete by query request.
+func (c *ElasticsearchClient) PurgeExpired(ctx context.Context, collection string, path []string, now time.Time) (int64, error) {
+	qry := &types.Query{Range: map[string]types.RangeQuery{
+		strings.Join(path, "."): map[string]any{"lte": now.UTC().Format(time.RFC3339)},
+	}}
+
+	res, err := c.cl.DeleteByQuery(collection).Query(qry).Conflicts(conflicts.Proceed).Do(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse username: %w", err)
+		// -- nothing expires from an index which does not exist
+		var esErr *types.ElasticsearchError
+		if errors.As(err, &esErr) && esErr.Status == http.StatusNotFound {
+			return 0, nil
+		}
+
+		return 0, err
 	}
-	result.Username = username
 
-	password, err := conf.FieldString("password")
+	if res.Deleted == nil {
+		return 0, nil
+	}
+
+	return *res.Deleted, nil
+}
+
+func (c *ElasticsearchClient) Count(ctx context.Context, collection string, q any) (int64, error) {
+	qry, err := esFilterQuery(q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse password: %w", err)
+		return 0, err
 	}
-	result.Password = password
 
-	cloudID, err := conf.FieldString("cloud_id")
+	res, err := c.cl.Count().Index(collection).Query(qry).Do(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse cloud_id: %w", err)
+		return 0, fmt.Errorf("failed to count documents: %w", err)
 	}
-	result.CloudID = cloudID
 
-	apiKey, err := conf.FieldString("api_key")
+	return res.Count, nil
+}
+
+// Aggregate calculates the metrics using a composite aggregation when grouping the documents, reading the groups in
+// pages until all of them have been read.
+func (c *ElasticsearchClient) Aggregate(ctx context.Context, collection string, q any, agg Aggregation) ([]map[string]any, error) {
+	qry, err := esFilterQuery(q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse api_key: %w", err)
+		return nil, err
+	}
+
+	metrics := esMetricAggregations(agg.Metrics)
+
+	if len(agg.GroupBy) == 0 {
+		body := search.NewRequest()
+		body.Query = qry
+		body.Size = new(int)
+		body.TrackTotalHits = true
+		body.Aggregations = metrics
+
+		var res esAggregateResponse
+		if err := c.aggregate(ctx, collection, body, &res); err != nil {
+			return nil, err
+		}
+
+		group, err := esGroup(nil, res.Hits.Total.Value, res.Aggregations, agg)
+		if err != nil {
+			return nil, err
+		}
+
+		return []map[string]any{group}, nil
+	}
+
+	var sources []map[string]types.CompositeAggregationSource
+	for i, path := range agg.GroupBy {
+		field := strings.Join(path, ".")
+		missing := true
+		sources = append(sources, map[string]types.CompositeAggregationSource{
+			fmt.Sprintf("g%d", i): {Terms: &types.TermsAggregation{Field: &field, MissingBucket: &missing}},
+		})
+	}
+
+	size := esDefaultPageSize
+	composite := &types.CompositeAggregation{Sources: sources, Size: &size}
+
+	var result []map[string]any
+	for {
+		body := search.NewRequest()
+		body.Query = qry
+		body.Size = new(int)
+		body.Aggregations = map[string]types.Aggregations{
+			"groups": {Composite: composite, Aggregations: metrics},
+		}
+
+		var res esAggregateResponse
+		if err := c.aggregate(ctx, collection, body, &res); err != nil {
+			return nil, err
+		}
+
+		var groups esCompositeResult
+		if err := json.Unmarshal(res.Aggregations["groups"], &groups); err != nil {
+			return nil, fmt.Errorf("failed to decode groups: %w", err)
+		}
+
+		for _, bucket := range groups.Buckets {
+			group, err := esGroup(bucket.Key, bucket.DocCount, bucket.Aggregations, agg)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, group)
+		}
+
+		if len(groups.Buckets) < size || len(groups.AfterKey) == 0 {
+			return result, nil
+		}
+
+		composite.After = groups.AfterKey
+	}
+}
+
+func (c *ElasticsearchClient) aggregate(ctx context.Context, collection string, body *search.Request, target any) error {
+	if c.logger != nil {
+		b, _ := json.Marshal(body)
+		c.logger.Debugf("executing %s", string(b))
 	}
-	result.APIKey = apiKey
 
-	serviceToken, err := conf.FieldString("service_token")
+	resp, err := c.cl.Search().Index(collection).Request(body).Perform(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse service_token: %w", err)
+		return err
 	}
-	result.ServiceToken = serviceToken
+	defer resp.Body.Close()
 
-	certificateFingerprint, err := conf.FieldString("certificate_fingerprint")
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse certificate_fingerprint: %w", err)
+		return fmt.Errorf("failed to read aggregation response: %w", err)
 	}
-	result.CertificateFingerprint = certificateFingerprint
 
-	return result, nil
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("aggregation failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	if err := json.Unmarshal(b, target); err != nil {
+		return fmt.Errorf("failed to decode aggregation response: %w", err)
+	}
+
+	return nil
 }
 
-type ElasticsearchClient struct {
-	cl     *elasticsearch.TypedClient
-	logger *service.Logger
+type esAggregateResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+	} `json:"hits"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
 }
 
-func (c *ElasticsearchClient) ParseQuery(config string) (any, error) {
-	var subQry types.Query
-	if err := json.Unmarshal([]byte(config), &subQry); err != nil {
-		return nil, fmt.Errorf("failed to parse query: %w", err)
+type esCompositeResult struct {
+	AfterKey types.CompositeAggregateKey `json:"after_key"`
+	Buckets  []esCompositeBucket         `json:"buckets"`
+}
+
+type esCompositeBucket struct {
+	Key          map[string]any
+	DocCount     int64
+	Aggregations map[string]json.RawMessage
+}
+
+func (b *esCompositeBucket) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
 	}
-	return &subQry, nil
+
+	if err := json.Unmarshal(fields["key"], &b.Key); err != nil {
+		return fmt.Errorf("failed to decode bucket key: %w", err)
+	}
+
+	if err := json.Unmarshal(fields["doc_count"], &b.DocCount); err != nil {
+		return fmt.Errorf("failed to decode bucket document count: %w", err)
+	}
+
+	// -- the metrics are the sub aggregations next to the key and document count
+	b.Aggregations = fields
+	return nil
 }
 
-func (c *ElasticsearchClient) List(ctx context.Context, collection string, q any, pitEnabled bool, paging *PagingOpts) (Cursor, error) {
-	if q == nil {
-		return nil, fmt.Errorf("query is nil")
+// esMetricAggregations returns the aggregations calculating the metrics. Counts are taken from the document count
+// instead.
+func esMetricAggregations(metrics []Metric) map[string]types.Aggregations {
+	result := map[string]types.Aggregations{}
+	for i, m := range metrics {
+		field := m.Field()
+		name := fmt.Sprintf("m%d", i)
+
+		switch m.Func {
+		case MetricSum:
+			result[name] = types.Aggregations{Sum: &types.SumAggregation{Field: &field}}
+		case MetricAvg:
+			result[name] = types.Aggregations{Avg: &types.AverageAggregation{Field: &field}}
+		case MetricMin:
+			result[name] = types.Aggregations{Min: &types.MinAggregation{Field: &field}}
+		case MetricMax:
+			result[name] = types.Aggregations{Max: &types.MaxAggregation{Field: &field}}
+		}
 	}
 
-	if pitEnabled {
-		qry, ok := q.(*types.Query)
-		if !ok {
-			return nil, fmt.Errorf("query is not a valid elasticsearch query; a pit query requires only the query part of a full search message")
+	return result
+}
+
+func esGroup(key map[string]any, docCount int64, aggs map[string]json.RawMessage, agg Aggregation) (map[string]any, error) {
+	result := map[string]any{}
+	for i, path := range agg.GroupBy {
+		result[strings.Join(path, ".")] = key[fmt.Sprintf("g%d", i)]
+	}
+
+	for i, m := range agg.Metrics {
+		if m.Func == MetricCount {
+			result[m.Name] = docCount
+			continue
 		}
 
-		resp, err := c.cl.OpenPointInTime(collection).KeepAlive("1m").Do(ctx)
-		if err != nil {
-			return nil, err
+		var value struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.Unmarshal(aggs[fmt.Sprintf("m%d", i)], &value); err != nil {
+			return nil, fmt.Errorf("failed to decode metric %s: %w", m.Name, err)
 		}
 
-		pit := &types.PointInTimeReference{
-			Id:        resp.Id,
-			KeepAlive: "1m",
+		if value.Value != nil {
+			result[m.Name] = *value.Value
+		} else {
+			result[m.Name] = nil
 		}
+	}
 
-		sort := types.NewSortOptions()
-		sort.Doc_ = &types.ScoreSort{Order: &sortorder.Desc}
+	return result, nil
+}
 
-		if c.logger != nil {
-			b, _ := json.Marshal(qry)
-			c.logger.Debugf("executing %s", string(b))
+// esFilterQuery returns the query part of the supported query types, being a filter, a parsed query or a full search
+// request.
+func esFilterQuery(q any) (*types.Query, error) {
+	switch qt := q.(type) {
+	case *Filter:
+		compiled := esQuery(qt)
+		return &compiled, nil
+	case *types.Query:
+		return qt, nil
+	case string:
+		body := search.NewRequest()
+		if qt != "" {
+			if err := json.Unmarshal([]byte(qt), body); err != nil {
+				return nil, fmt.Errorf("failed to parse search message: %w", err)
+			}
 		}
 
-		req := c.cl.Search().Query(qry).Pit(pit).Sort(sort).Size(100).TrackScores(true)
+		if body.Query == nil {
+			return &types.Query{MatchAll: types.NewMatchAllQuery()}, nil
+		}
+		return body.Query, nil
+	default:
+		return nil, fmt.Errorf("query is not a valid elasticsearch query")
+	}
+}
 
-		return newEsCursor(ctx, c.cl, req, pit)
-	} else {
-		qry, ok := q.(string)
-		if !ok {
-			return nil, fmt.Errorf("a full search message is required when pits are disabled")
+func esSortOptions(sorting []SortField) []types.SortCombinations {
+	var result []types.SortCombinations
+	for _, sf := range sorting {
+		order := sortorder.Asc
+		if sf.Descending {
+			order = sortorder.Desc
 		}
 
-		req := c.cl.Search().Raw(bytes.NewReader([]byte(qry)))
-		return newEsCursor(ctx, c.cl, req, nil)
+		result = append(result, types.SortOptions{
+			SortOptions: map[string]types.FieldSort{
+				sf.Field(): {Order: &order},
+			},
+		})
 	}
+
+	return result
 }
 
 func (c *ElasticsearchClient) Get(ctx context.Context, collection string, key string) (map[string]any, error) {
+	doc, _, err := c.GetRevision(ctx, collection, key)
+	return doc, err
+}
+
+func (c *ElasticsearchClient) GetRevision(ctx context.Context, collection string, key string) (map[string]any, string, error) {
 	res, err := c.cl.Get(collection, key).Do(ctx)
 	if err != nil {
-		return nil, err
+		// -- a missing index means a missing document
+		return nil, "", esRevisionError(err)
 	}
 	if !res.Found {
-		return nil, service.ErrKeyNotFound
+		return nil, "", ErrNotFound
 	}
 
 	var result map[string]any
 	if err := json.Unmarshal(res.Source_, &result); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return result, nil
+	var rev string
+	if res.SeqNo_ != nil && res.PrimaryTerm_ != nil {
+		rev = esRevision(*res.SeqNo_, *res.PrimaryTerm_)
+	}
+
+	return result, rev, nil
 }
 
 func (c *ElasticsearchClient) Set(ctx context.Context, collection string, key string, value map[string]any) error {
+	_, err := c.SetRevision(ctx, collection, key, value, "")
+	return err
+}
+
+func (c *ElasticsearchClient) SetRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (string, error) {
 	b, err := json.Marshal(value)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	_, err = c.cl.Index(collection).Id(key).Raw(bytes.NewBuffer(b)).Refresh(refresh.True).Do(ctx)
+	req := c.cl.Index(collection).Id(key).Raw(bytes.NewBuffer(b)).Refresh(c.refresh)
+	if expected != "" {
+		seqNo, primaryTerm, err := parseEsRevision(expected)
+		if err != nil {
+			return "", err
+		}
+		req = req.IfSeqNo(seqNo).IfPrimaryTerm(primaryTerm)
+	}
+
+	res, err := req.Do(ctx)
 	if err != nil {
-		return err
+		return "", esRevisionError(err)
 	}
 
-	return nil
+	return esRevision(res.SeqNo_, res.PrimaryTerm_), nil
 }
 
 func (c *ElasticsearchClient) Merge(ctx context.Context, collection string, key string, value map[string]any) (map[string]any, error) {
-	_, hasDoc := value["doc"]
-	_, hasScript := value["script"]
+	doc, _, err := c.MergeRevision(ctx, collection, key, value, "")
+	return doc, err
+}
+
+// MergeRevision merges the value into the document using a single update request. Without an expected revision the
+// document is created if it does not exist yet and conflicting concurrent updates are retried. With an expected
+// revision, the document must exist and be at that revision.
+//
+// The value is either the document to merge, or a full update request body holding a `doc` or `script`.
+func (c *ElasticsearchClient) MergeRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (map[string]any, string, error) {
+	body := esUpdateBody(value, expected == "")
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req := c.cl.Update(collection, key).
+		Raw(bytes.NewBuffer(b)).
+		Refresh(c.refresh)
 
-	if !hasDoc && !hasScript {
-		value = map[string]any{
-			"doc": value,
+	if expected != "" {
+		seqNo, primaryTerm, err := parseEsRevision(expected)
+		if err != nil {
+			return nil, "", err
 		}
+		req = req.IfSeqNo(seqNo).IfPrimaryTerm(primaryTerm)
+	} else if c.retryOnConflict > 0 {
+		// -- retrying is not allowed in combination with a revision check
+		req = req.RetryOnConflict(c.retryOnConflict)
 	}
 
-	b, err := json.Marshal(value)
+	res, err := req.Do(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", esRevisionError(err)
 	}
 
-	fnd, err := c.cl.Exists(collection, key).IsSuccess(ctx)
-	if err != nil {
-		return nil, err
+	if res.Get == nil || res.Get.Source_ == nil {
+		return nil, "", fmt.Errorf("the update response of document %q holds no document", key)
 	}
 
-	if fnd {
-		_, err := c.cl.Update(collection, key).
-			Raw(bytes.NewBuffer(b)).
-			Refresh(refresh.True).
-			Do(ctx)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		if err = c.Add(ctx, collection, key, value); err != nil {
-			return nil, err
-		}
+	var result map[string]any
+	if err := json.Unmarshal(res.Get.Source_, &result); err != nil {
+		return nil, "", err
+	}
+
+	return result, esRevision(res.SeqNo_, res.PrimaryTerm_), nil
+}
+
+// esUpdateBody creates the body of an update request merging the value into the document and returning the updated
+// document. The value is always merged as a partial document, whichever attributes it holds.
+func esUpdateBody(value map[string]any, upsert bool) map[string]any {
+	body := map[string]any{"doc": value, "_source": true}
+	if upsert {
+		body["doc_as_upsert"] = true
 	}
 
-	return c.Get(ctx, collection, key)
+	return body
 }
 
 func (c *ElasticsearchClient) Add(ctx context.Context, collection string, key string, value map[string]any) error {
@@ -241,9 +682,15 @@ func (c *ElasticsearchClient) Add(ctx context.Context, collection string, key st
 	_, err = c.cl.Index(collection).
 		Id(key).
 		Raw(bytes.NewBuffer(b)).
-		Refresh(refresh.True).
+		Refresh(c.refresh).
 		OpType(optype.Create).
 		Do(ctx)
+
+	var esErr *types.ElasticsearchError
+	if errors.As(err, &esErr) && esErr.Status == http.StatusConflict && esErr.ErrorCause.Type == "version_conflict_engine_exception" {
+		return service.ErrKeyAlreadyExists
+	}
+
 	if err != nil {
 		return err
 	}
@@ -252,16 +699,161 @@ func (c *ElasticsearchClient) Add(ctx context.Context, collection string, key st
 }
 
 func (c *ElasticsearchClient) Delete(ctx context.Context, collection string, key string) error {
-	_, err := c.cl.Delete(collection, key).Refresh(refresh.True).Do(ctx)
+	return c.DeleteRevision(ctx, collection, key, "")
+}
+
+func (c *ElasticsearchClient) DeleteRevision(ctx context.Context, collection string, key string, expected string) error {
+	req := c.cl.Delete(collection, key).Refresh(c.refresh)
+	if expected != "" {
+		seqNo, primaryTerm, err := parseEsRevision(expected)
+		if err != nil {
+			return err
+		}
+		req = req.IfSeqNo(seqNo).IfPrimaryTerm(primaryTerm)
+	}
+
+	res, err := req.Do(ctx)
 	if err != nil {
+		return esRevisionError(err)
+	}
+
+	if res.Result == result.Notfound {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// esRevision encodes the sequence number and primary term of a document as a revision.
+func esRevision(seqNo int64, primaryTerm int64) string {
+	return fmt.Sprintf("%d:%d", seqNo, primaryTerm)
+}
+
+func parseEsRevision(rev string) (seqNo string, primaryTerm string, err error) {
+	seqNo, primaryTerm, fnd := strings.Cut(rev, ":")
+	if !fnd || seqNo == "" || primaryTerm == "" {
+		return "", "", fmt.Errorf("invalid revision %q, expected <seq_no>:<primary_term>", rev)
+	}
+
+	return seqNo, primaryTerm, nil
+}
+
+// esRevisionError translates the error returned by a (conditional) request on a document.
+func esRevisionError(err error) error {
+	var esErr *types.ElasticsearchError
+	if !errors.As(err, &esErr) {
+		return err
+	}
+
+	switch esErr.Status {
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrRevisionMismatch, err)
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
 		return err
 	}
+}
+
+// Bulk applies the operations using a single request to the `_bulk` API.
+func (c *ElasticsearchClient) Bulk(ctx context.Context, ops []BulkOperation) ([]error, error) {
+	body, err := esBulkBody(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cl.Bulk().Raw(bytes.NewReader(body)).Refresh(c.refresh).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Items) != len(ops) {
+		return nil, fmt.Errorf("expected %d bulk items, got %d", len(ops), len(resp.Items))
+	}
+
+	errs := make([]error, len(ops))
+	for i, item := range resp.Items {
+		for _, ri := range item {
+			errs[i] = esBulkItemError(ri)
+		}
+	}
+
+	return errs, nil
+}
+
+// esBulkBody creates the newline delimited body of a bulk request.
+func esBulkBody(ops []BulkOperation) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, op := range ops {
+		meta := map[string]any{"_index": op.Collection, "_id": op.Key}
+
+		var action string
+		switch op.Operation {
+		case "set":
+			action = "index"
+		case "add":
+			action = "create"
+		case "delete":
+			action = "delete"
+		default:
+			return nil, fmt.Errorf("operation %q is not supported in bulk", op.Operation)
+		}
+
+		if err := enc.Encode(map[string]any{action: meta}); err != nil {
+			return nil, err
+		}
+
+		if op.Operation == "delete" {
+			continue
+		}
+
+		if err := enc.Encode(op.Value); err != nil {
+			return nil, fmt.Errorf("failed to encode document %q: %w", op.Key, err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func esBulkItemError(item types.ResponseItem) error {
+	if item.Error == nil {
+		return nil
+	}
+
+	switch item.Status {
+	case http.StatusConflict:
+		if item.Error.Type == "version_conflict_engine_exception" {
+			return service.ErrKeyAlreadyExists
+		}
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+
+	reason := ""
+	if item.Error.Reason != nil {
+		reason = *item.Error.Reason
+	}
+
+	return fmt.Errorf("%s: %s", item.Error.Type, reason)
+}
+
+func (c *ElasticsearchClient) Ping(ctx context.Context) error {
+	ok, err := c.cl.Ping().IsSuccess(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reach elasticsearch: %w", err)
+	}
+
+	if !ok {
+		return fmt.Errorf("elasticsearch is not available")
+	}
 
 	return nil
 }
 
 func (c *ElasticsearchClient) Close() error {
-	return nil
+	return c.conn.Close()
 }
 
 func newEsCursor(ctx context.Context, cl *elasticsearch.TypedClient, req *search.Search, pit *types.PointInTimeReference) (Cursor, error) {
@@ -367,6 +959,8 @@ func (c *pagingEsCursor) loadMore() error {
 
 	resp, err := req.Do(c.ctx)
 	if err != nil {
+		// -- the cursor is exhausted, rather than pointing past the previous page
+		c.hits, c.pageOffset = nil, 0
 		return err
 	}
 
@@ -375,3 +969,192 @@ func (c *pagingEsCursor) loadMore() error {
 
 	return nil
 }
+
+// Changes polls every shard of the index for documents with a sequence number beyond the last one seen on that shard.
+// Documents at their first version are reported as created. Deletes can not be observed, the documents being gone.
+// Checkpoints hold the last sequence number seen per shard.
+func (c *ElasticsearchClient) Changes(ctx context.Context, collection string, opts ChangeOpts) (ChangeFeed, error) {
+	shards, err := c.shardCount(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &esChangeFeed{
+		cl:           c,
+		index:        collection,
+		pollInterval: opts.PollInterval,
+		batchSize:    opts.BatchSize,
+	}
+
+	if feed.batchSize <= 0 {
+		feed.batchSize = esDefaultPageSize
+	}
+
+	switch {
+	case opts.Checkpoint != "":
+		if err := json.Unmarshal([]byte(opts.Checkpoint), &feed.seqNos); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint %q: %w", opts.Checkpoint, err)
+		}
+
+		if len(feed.seqNos) != shards {
+			return nil, fmt.Errorf("checkpoint %q does not match the %d shards of %s", opts.Checkpoint, shards, collection)
+		}
+	case opts.FromStart:
+		feed.seqNos = make([]int64, shards)
+		for i := range feed.seqNos {
+			feed.seqNos[i] = -1
+		}
+	default:
+		feed.seqNos = make([]int64, shards)
+		for i := range feed.seqNos {
+			hits, err := feed.poll(ctx, i, 1, true)
+			if err != nil {
+				return nil, err
+			}
+
+			feed.seqNos[i] = -1
+			if len(hits) > 0 && hits[0].SeqNo_ != nil {
+				feed.seqNos[i] = *hits[0].SeqNo_
+			}
+		}
+	}
+
+	return feed, nil
+}
+
+func (c *ElasticsearchClient) shardCount(ctx context.Context, collection string) (int, error) {
+	resp, err := c.cl.Indices.GetSettings().Index(collection).Name("index.number_of_shards").FlatSettings(true).Perform(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the settings of %s: %w", collection, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, ErrNotFound
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, fmt.Errorf("failed to get the settings of %s: status %d", collection, resp.StatusCode)
+	}
+
+	var settings map[string]struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return 0, fmt.Errorf("failed to decode the settings of %s: %w", collection, err)
+	}
+
+	// -- aliases may refer to multiple indices, of which the shards can not be told apart
+	if len(settings) != 1 {
+		return 0, fmt.Errorf("changes can only be tailed from a single index, %s refers to %d", collection, len(settings))
+	}
+
+	for _, idx := range settings {
+		return strconv.Atoi(idx.Settings["index.number_of_shards"])
+	}
+
+	return 0, nil
+}
+
+type esChangeFeed struct {
+	cl           *ElasticsearchClient
+	index        string
+	pollInterval time.Duration
+	batchSize    int
+
+	// seqNos holds the last sequence number seen per shard
+	seqNos []int64
+
+	// next is the shard polled first, which moves past the shard filling a batch so the other shards get their turn
+	next int
+}
+
+func (f *esChangeFeed) Next(ctx context.Context) ([]Change, error) {
+	for {
+		var result []Change
+		for i := range f.seqNos {
+			shard := (f.next + i) % len(f.seqNos)
+			hits, err := f.poll(ctx, shard, f.batchSize-len(result), false)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, hit := range hits {
+				change, err := f.change(shard, hit)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, change)
+			}
+
+			if len(result) >= f.batchSize {
+				f.next = (shard + 1) % len(f.seqNos)
+				break
+			}
+		}
+
+		if len(result) > 0 {
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
+
+// poll returns the documents of the shard with a sequence number beyond the last one seen, or the document with the
+// highest sequence number if latest is set.
+func (f *esChangeFeed) poll(ctx context.Context, shard int, size int, latest bool) ([]types.Hit, error) {
+	enabled := true
+
+	body := search.NewRequest()
+	body.Size = &size
+	body.Version = &enabled
+	body.SeqNoPrimaryTerm = &enabled
+	body.Sort = esSortOptions([]SortField{{Path: []string{"_seq_no"}, Descending: latest}})
+
+	if !latest {
+		gt := types.Float64(f.seqNos[shard])
+		body.Query = &types.Query{Range: map[string]types.RangeQuery{
+			"_seq_no": types.NumberRangeQuery{Gt: &gt},
+		}}
+	}
+
+	resp, err := f.cl.cl.Search().Index(f.index).Preference(fmt.Sprintf("_shards:%d", shard)).Request(body).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll shard %d of %s: %w", shard, f.index, err)
+	}
+
+	return resp.Hits.Hits, nil
+}
+
+func (f *esChangeFeed) change(shard int, hit types.Hit) (Change, error) {
+	if hit.SeqNo_ == nil {
+		return Change{}, fmt.Errorf("document %s holds no sequence number", hit.Id_)
+	}
+	f.seqNos[shard] = *hit.SeqNo_
+
+	checkpoint, err := json.Marshal(f.seqNos)
+	if err != nil {
+		return Change{}, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(hit.Source_, &doc); err != nil {
+		return Change{}, fmt.Errorf("failed to decode document %s: %w", hit.Id_, err)
+	}
+
+	result := Change{Operation: ChangeUpdate, Collection: f.index, Key: hit.Id_, After: doc, Checkpoint: string(checkpoint)}
+	if hit.Version_ != nil && *hit.Version_ == 1 {
+		result.Operation = ChangeCreate
+	}
+
+	return result, nil
+}
+
+func (f *esChangeFeed) Close() error {
+	return nil
+}
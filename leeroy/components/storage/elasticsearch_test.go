@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_EsBulkBody(t *testing.T) {
+	body, err := esBulkBody([]BulkOperation{
+		{Operation: "set", Collection: "concepts", Key: "a", Value: map[string]any{"name": "alpha"}},
+		{Operation: "add", Collection: "concepts", Key: "b", Value: map[string]any{"name": "beta"}},
+		{Operation: "delete", Collection: "concepts", Key: "c"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `{"index":{"_id":"a","_index":"concepts"}}
+{"name":"alpha"}
+{"create":{"_id":"b","_index":"concepts"}}
+{"name":"beta"}
+{"delete":{"_id":"c","_index":"concepts"}}
+`, string(body))
+}
+
+func Test_EsUpdateBody(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    map[string]any
+		upsert   bool
+		expected map[string]any
+	}{
+		{
+			"should upsert a plain document",
+			map[string]any{"name": "alpha"},
+			true,
+			map[string]any{"doc": map[string]any{"name": "alpha"}, "doc_as_upsert": true, "_source": true},
+		},
+		{
+			"should not upsert when a revision is expected",
+			map[string]any{"name": "alpha"},
+			false,
+			map[string]any{"doc": map[string]any{"name": "alpha"}, "_source": true},
+		},
+		{
+			"should merge documents holding update attributes",
+			map[string]any{"doc": "manual", "script": "setup.sh", "upsert": true},
+			true,
+			map[string]any{"doc": map[string]any{"doc": "manual", "script": "setup.sh", "upsert": true}, "doc_as_upsert": true, "_source": true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, esUpdateBody(c.value, c.upsert))
+		})
+	}
+}
+
+func Test_EsQuery(t *testing.T) {
+	flt, err := ParseFilterDocument(map[string]any{
+		"status": map[string]any{"$in": []any{"open", "pending"}},
+		"total":  map[string]any{"$gte": 10},
+		"$or": []any{
+			map[string]any{"owner": map[string]any{"$exists": false}},
+			map[string]any{"$not": map[string]any{"priority": "low"}},
+		},
+	})
+	require.NoError(t, err)
+
+	b, err := json.Marshal(esQuery(flt))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"bool": {"filter": [
+		{"bool": {"minimum_should_match": 1, "should": [
+			{"bool": {"must_not": [{"exists": {"field": "owner"}}]}},
+			{"bool": {"must_not": [{"term": {"priority": {"value": "low"}}}]}}
+		]}},
+		{"terms": {"status": ["open", "pending"]}},
+		{"range": {"total": {"gte": 10}}}
+	]}}`, string(b))
+}
+
+func Test_EsGroup(t *testing.T) {
+	var groups esCompositeResult
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"after_key": {"g0": "b"},
+		"buckets": [
+			{"key": {"g0": "a"}, "doc_count": 2, "m1": {"value": 20}, "m2": {"value": null}},
+			{"key": {"g0": "b"}, "doc_count": 1, "m1": {"value": 0}, "m2": {"value": 7}}
+		]
+	}`), &groups))
+
+	agg := Aggregation{
+		GroupBy: [][]string{{"customer", "id"}},
+		Metrics: []Metric{
+			{Name: "orders", Func: MetricCount},
+			{Name: "revenue", Func: MetricSum, Path: []string{"total"}},
+			{Name: "largest", Func: MetricMax, Path: []string{"total"}},
+		},
+	}
+
+	require.Len(t, groups.Buckets, 2)
+	assert.Equal(t, "b", groups.AfterKey["g0"])
+
+	group, err := esGroup(groups.Buckets[0].Key, groups.Buckets[0].DocCount, groups.Buckets[0].Aggregations, agg)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"customer.id": "a", "orders": int64(2), "revenue": float64(20), "largest": nil}, group)
+}
+
+func Test_EsChangeFeed__shouldRotateTheFirstShard(t *testing.T) {
+	// -- shard 0 always holds a full batch of newer documents, while shard 1 holds a single one
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hits []map[string]any
+		switch r.URL.Query().Get("preference") {
+		case "_shards:0":
+			var body struct {
+				Size int `json:"size"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			for i := 0; i < body.Size; i++ {
+				hits = append(hits, map[string]any{"_index": "concepts", "_id": fmt.Sprintf("busy-%d", i), "_seq_no": i + 1, "_primary_term": 1, "_version": 2, "_source": map[string]any{}})
+			}
+		case "_shards:1":
+			hits = append(hits, map[string]any{"_index": "concepts", "_id": "quiet", "_seq_no": 1, "_primary_term": 1, "_version": 1, "_source": map[string]any{}})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"took":      1,
+			"timed_out": false,
+			"_shards":   map[string]any{"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+			"hits":      map[string]any{"total": map[string]any{"value": len(hits), "relation": "eq"}, "hits": hits},
+		})
+	}))
+	defer srv.Close()
+
+	cl, err := elasticsearch.NewTypedClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+
+	feed := &esChangeFeed{cl: &ElasticsearchClient{cl: cl}, index: "concepts", batchSize: 2, seqNos: []int64{0, 0}}
+
+	first, err := feed.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"busy-0", "busy-1"}, changeKeys(first))
+
+	second, err := feed.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"quiet", "busy-0"}, changeKeys(second))
+	assert.Equal(t, ChangeCreate, second[0].Operation)
+}
+
+func changeKeys(changes []Change) []string {
+	var result []string
+	for _, c := range changes {
+		result = append(result, c.Key)
+	}
+	return result
+}
+
+func Test_ElasticsearchClient__shouldReportExistingKeyOnAdd(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception","reason":"[a]: version conflict, document already exists"},"status":409}`))
+	}))
+	defer srv.Close()
+
+	cl, err := elasticsearch.NewTypedClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+
+	err = (&ElasticsearchClient{cl: cl}).Add(context.Background(), "concepts", "a", map[string]any{"name": "alpha"})
+	assert.ErrorIs(t, err, service.ErrKeyAlreadyExists)
+}
+
+func Test_PagingEsCursor__shouldEndAfterFailingToLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"},"status":500}`))
+	}))
+	defer srv.Close()
+
+	cl, err := elasticsearch.NewTypedClient(elasticsearch.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+
+	cur := &pagingEsCursor{ctx: context.Background(), cl: cl, req: cl.Search().Index("concepts"), hits: []types.Hit{{Source_: json.RawMessage(`{}`)}}}
+	require.True(t, cur.HasNext())
+
+	_, err = cur.Read()
+	assert.Error(t, err)
+	assert.False(t, cur.HasNext())
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExpiryClient is implemented by clients of stores able to remove expired documents by themselves.
+type ExpiryClient interface {
+	// EnsureExpiry makes sure documents of the collection are removed once the time held by the field at path has
+	// passed.
+	EnsureExpiry(ctx context.Context, collection string, path []string) error
+}
+
+// PurgeClient is implemented by clients removing expired documents on request.
+type PurgeClient interface {
+	// PurgeExpired removes the documents of the collection of which the time held by the field at path is not after
+	// now, returning the number of removed documents.
+	PurgeExpired(ctx context.Context, collection string, path []string, now time.Time) (int64, error)
+}
+
+// SupportsExpiry returns whether documents stored using the client can expire.
+func SupportsExpiry(cl Client) bool {
+	switch cl.(type) {
+	case ExpiryClient, PurgeClient:
+		return true
+	default:
+		return false
+	}
+}
+
+// expirer keeps track of the collections holding expiring documents. Collections of stores without native expiry are
+// purged periodically.
+type expirer struct {
+	cl       Client
+	path     []string
+	interval time.Duration
+	logger   *service.Logger
+
+	mu          sync.Mutex
+	collections map[string]bool
+	purged      []string
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func newExpirer(cl Client, path []string, interval time.Duration, logger *service.Logger) *expirer {
+	return &expirer{
+		cl:          cl,
+		path:        path,
+		interval:    interval,
+		logger:      logger,
+		collections: map[string]bool{},
+	}
+}
+
+// expiresAt returns the expiry time to store for a document living for the given duration.
+func (e *expirer) expiresAt(now time.Time, ttl time.Duration) string {
+	return now.Add(ttl).UTC().Format(time.RFC3339)
+}
+
+// track makes sure expired documents will be removed from the collection.
+func (e *expirer) track(ctx context.Context, collection string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.collections[collection] {
+		return nil
+	}
+
+	if ec, ok := e.cl.(ExpiryClient); ok {
+		if err := ec.EnsureExpiry(ctx, collection, e.path); err != nil {
+			return fmt.Errorf("failed to ensure expiry on %s: %w", collection, err)
+		}
+	} else {
+		e.purged = append(e.purged, collection)
+		if e.stop == nil {
+			e.stop, e.done = make(chan struct{}), make(chan struct{})
+			go e.run(e.stop, e.done)
+		}
+	}
+
+	e.collections[collection] = true
+	return nil
+}
+
+func (e *expirer) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			e.purge(now)
+		}
+	}
+}
+
+func (e *expirer) purge(now time.Time) {
+	pc, ok := e.cl.(PurgeClient)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	collections := append([]string(nil), e.purged...)
+	e.mu.Unlock()
+
+	for _, col := range collections {
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+		cnt, err := pc.PurgeExpired(ctx, col, e.path, now)
+		cancel()
+
+		if e.logger == nil {
+			continue
+		}
+
+		if err != nil {
+			e.logger.Warnf("failed to purge expired documents from %s: %v", col, err)
+		} else if cnt > 0 {
+			e.logger.Debugf("purged %d expired documents from %s", cnt, col)
+		}
+	}
+}
+
+// Close stops purging collections.
+func (e *expirer) Close() {
+	e.mu.Lock()
+	stop, done := e.stop, e.done
+	e.stop = nil
+	e.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// expired reports whether the value holds a time which is not after now.
+func expired(v any, now time.Time) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+	return err == nil && !t.After(now)
+}
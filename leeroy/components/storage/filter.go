@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Operators of a Filter.
+const (
+	FilterAnd    = "and"
+	FilterOr     = "or"
+	FilterNot    = "not"
+	FilterEq     = "eq"
+	FilterNe     = "ne"
+	FilterLt     = "lt"
+	FilterLte    = "lte"
+	FilterGt     = "gt"
+	FilterGte    = "gte"
+	FilterIn     = "in"
+	FilterExists = "exists"
+)
+
+// Filter is a driver neutral condition on documents which every driver compiles into its native query language. A nil
+// filter matches all documents.
+//
+// The logical operators (and, or, not) combine the sub filters. The other operators compare the value of the field at
+// Path with Value, where `in` expects Value to be a list and `exists` a boolean. A field only exists if it holds a
+// non-null value. Fields missing from a document never match a range, while they do match `ne`.
+type Filter struct {
+	Op      string
+	Path    []string
+	Value   any
+	Filters []*Filter
+}
+
+// Field returns the dotted path of the field the filter applies to.
+func (f *Filter) Field() string {
+	return strings.Join(f.Path, ".")
+}
+
+var filterDocumentOperators = map[string]string{
+	"$eq":     FilterEq,
+	"$ne":     FilterNe,
+	"$lt":     FilterLt,
+	"$lte":    FilterLte,
+	"$gt":     FilterGt,
+	"$gte":    FilterGte,
+	"$in":     FilterIn,
+	"$exists": FilterExists,
+}
+
+// ParseFilterDocument parses a filter from its document form. Each key of the document is either a dotted field path
+// or one of the logical operators `$and`, `$or` (both holding a list of filter documents) and `$not` (holding a filter
+// document). The value of a field is either the value the field must be equal to, or an object of operators being
+// `$eq`, `$ne`, `$lt`, `$lte`, `$gt`, `$gte`, `$in` and `$exists`. All conditions within a document need to match, e.g.
+//
+//	{"status": "open", "total": {"$gte": 10}, "$or": [{"priority": "high"}, {"owner": {"$exists": false}}]}
+func ParseFilterDocument(doc map[string]any) (*Filter, error) {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := &Filter{Op: FilterAnd}
+	for _, k := range keys {
+		var err error
+		var sub []*Filter
+
+		switch k {
+		case "$and", "$or":
+			var list *Filter
+			if list, err = parseFilterList(k, doc[k]); err == nil {
+				sub = []*Filter{list}
+			}
+		case "$not":
+			var nested *Filter
+			if nested, err = parseNestedFilter(k, doc[k]); err == nil {
+				sub = []*Filter{{Op: FilterNot, Filters: []*Filter{nested}}}
+			}
+		default:
+			if strings.HasPrefix(k, "$") {
+				return nil, fmt.Errorf("unknown filter operator %q", k)
+			}
+			sub, err = parseFieldFilters(k, doc[k])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Filters = append(result.Filters, sub...)
+	}
+
+	if len(result.Filters) == 1 {
+		return result.Filters[0], nil
+	}
+
+	return result, nil
+}
+
+func parseFilterList(op string, v any) (*Filter, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s requires a list of filters, got %T", op, v)
+	}
+
+	result := &Filter{Op: FilterAnd}
+	if op == "$or" {
+		result.Op = FilterOr
+	}
+
+	for _, item := range items {
+		f, err := parseNestedFilter(op, item)
+		if err != nil {
+			return nil, err
+		}
+		result.Filters = append(result.Filters, f)
+	}
+
+	return result, nil
+}
+
+func parseNestedFilter(op string, v any) (*Filter, error) {
+	doc, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s requires a filter object, got %T", op, v)
+	}
+
+	return ParseFilterDocument(doc)
+}
+
+func parseFieldFilters(field string, v any) ([]*Filter, error) {
+	path := strings.Split(field, ".")
+
+	ops, ok := v.(map[string]any)
+	if !ok || len(ops) == 0 || !isOperatorObject(ops) {
+		return []*Filter{{Op: FilterEq, Path: path, Value: v}}, nil
+	}
+
+	keys := make([]string, 0, len(ops))
+	for k := range ops {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result []*Filter
+	for _, k := range keys {
+		op, fnd := filterDocumentOperators[k]
+		if !fnd {
+			return nil, fmt.Errorf("unknown operator %q for field %q", k, field)
+		}
+
+		value := ops[k]
+		switch op {
+		case FilterIn:
+			if _, ok := value.([]any); !ok {
+				return nil, fmt.Errorf("%s on field %q requires a list, got %T", k, field, value)
+			}
+		case FilterExists:
+			if _, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("%s on field %q requires a boolean, got %T", k, field, value)
+			}
+		}
+
+		result = append(result, &Filter{Op: op, Path: path, Value: value})
+	}
+
+	return result, nil
+}
+
+// isOperatorObject reports whether all keys of the object are operators. Objects mixing operators and plain keys are
+// rejected by treating them as operators.
+func isOperatorObject(obj map[string]any) bool {
+	for k := range obj {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+
+	return false
+}
+
+var filterOperators = []struct {
+	token string
+	op    string
+}{
+	{"==", FilterEq},
+	{"!=", FilterNe},
+	{"<=", FilterLte},
+	{">=", FilterGte},
+	{"<", FilterLt},
+	{">", FilterGt},
+}
+
+// parseFilter parses the filter language of the drivers lacking a native one. The language is a list of conditions
+// separated by `AND`, each condition being a dotted field path, an operator (one of `==`, `!=`, `<`, `<=`, `>` or `>=`)
+// and a JSON value, e.g. `status == "open" AND total >= 10`.
+func parseFilter(q string) (*Filter, error) {
+	rest := strings.TrimSpace(q)
+	if rest == "" {
+		return nil, nil
+	}
+
+	result := &Filter{Op: FilterAnd}
+	for {
+		cond, remaining, err := parseCondition(rest)
+		if err != nil {
+			return nil, err
+		}
+		result.Filters = append(result.Filters, cond)
+
+		// -- the value is either the last one or followed by the next condition
+		trimmed := strings.TrimLeft(remaining, " \t\r\n")
+		if trimmed == "" {
+			return result, nil
+		}
+
+		next, fnd := strings.CutPrefix(trimmed, "AND")
+		if !fnd || trimmed == remaining || strings.TrimLeft(next, " \t\r\n") == next {
+			return nil, fmt.Errorf("expected AND before %q", trimmed)
+		}
+		rest = strings.TrimSpace(next)
+	}
+}
+
+// parseCondition parses the condition at the start of s, returning what follows it. The value is read as a single JSON
+// value, so operators and `AND` within quoted values are left alone.
+func parseCondition(s string) (*Filter, string, error) {
+	idx := strings.IndexAny(s, "=!<>")
+	if idx < 0 {
+		return nil, "", fmt.Errorf("no valid operator in condition %q", s)
+	}
+
+	path := strings.TrimSpace(s[:idx])
+	if path == "" {
+		return nil, "", fmt.Errorf("missing field in condition %q", s)
+	}
+
+	for _, fo := range filterOperators {
+		if !strings.HasPrefix(s[idx:], fo.token) {
+			continue
+		}
+
+		dec := json.NewDecoder(strings.NewReader(s[idx+len(fo.token):]))
+
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return nil, "", fmt.Errorf("invalid value in condition %q: %w", s, err)
+		}
+
+		return &Filter{Op: fo.op, Path: strings.Split(path, "."), Value: value}, s[idx+len(fo.token)+int(dec.InputOffset()):], nil
+	}
+
+	return nil, "", fmt.Errorf("no valid operator in condition %q", s)
+}
+
+// Matches reports whether the document matches the filter.
+func (f *Filter) Matches(doc map[string]any) bool {
+	if f == nil {
+		return true
+	}
+
+	switch f.Op {
+	case FilterAnd:
+		for _, sub := range f.Filters {
+			if !sub.Matches(doc) {
+				return false
+			}
+		}
+		return true
+	case FilterOr:
+		for _, sub := range f.Filters {
+			if sub.Matches(doc) {
+				return true
+			}
+		}
+		return false
+	case FilterNot:
+		for _, sub := range f.Filters {
+			if sub.Matches(doc) {
+				return false
+			}
+		}
+		return true
+	}
+
+	v, fnd := lookupPath(doc, f.Path)
+	fnd = fnd && v != nil
+
+	switch f.Op {
+	case FilterEq:
+		return fnd && valuesEqual(v, f.Value)
+	case FilterNe:
+		return !fnd || !valuesEqual(v, f.Value)
+	case FilterExists:
+		return fnd == f.Value
+	case FilterIn:
+		if !fnd {
+			return false
+		}
+		values, _ := f.Value.([]any)
+		for _, candidate := range values {
+			if valuesEqual(v, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !fnd {
+		return false
+	}
+
+	cmp, ok := compareValues(v, f.Value)
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case FilterLt:
+		return cmp < 0
+	case FilterLte:
+		return cmp <= 0
+	case FilterGt:
+		return cmp > 0
+	case FilterGte:
+		return cmp >= 0
+	default:
+		return false
+	}
+}
+
+func lookupPath(doc map[string]any, path []string) (any, bool) {
+	var current any = doc
+	for _, p := range path {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[p]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func valuesEqual(a, b any) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues compares two numbers or two strings, returning false if the values are not comparable.
+func compareValues(a, b any) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	as, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+
+	bs, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+
+	return strings.Compare(as, bs), true
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Filter__shouldMatch(t *testing.T) {
+	doc := map[string]any{
+		"status": "open",
+		"total":  float64(12),
+		"customer": map[string]any{
+			"name": "alpha",
+		},
+	}
+
+	cases := []struct {
+		name    string
+		q       string
+		matches bool
+	}{
+		{"empty filter", "", true},
+		{"string equality", `status == "open"`, true},
+		{"string inequality", `status != "open"`, false},
+		{"nested field", `customer.name == "alpha"`, true},
+		{"missing field", `customer.code == "a"`, false},
+		{"missing field inequality", `customer.code != "a"`, true},
+		{"greater than", `total > 10`, true},
+		{"less than or equal", `total <= 10`, false},
+		{"conjunction", `status == "open" AND total >= 12`, true},
+		{"failing conjunction", `status == "open" AND total < 12`, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := parseFilter(c.q)
+			require.NoError(t, err)
+			assert.Equal(t, c.matches, f.Matches(doc))
+		})
+	}
+}
+
+func Test_Filter__shouldMatchDocumentFilters(t *testing.T) {
+	doc := map[string]any{
+		"status":   "open",
+		"total":    float64(12),
+		"priority": nil,
+		"customer": map[string]any{
+			"name": "alpha",
+		},
+	}
+
+	cases := []struct {
+		name    string
+		filter  map[string]any
+		matches bool
+	}{
+		{"empty filter", map[string]any{}, true},
+		{"equality shorthand", map[string]any{"status": "open"}, true},
+		{"explicit equality", map[string]any{"status": map[string]any{"$eq": "closed"}}, false},
+		{"nested field", map[string]any{"customer.name": "alpha"}, true},
+		{"range", map[string]any{"total": map[string]any{"$gt": 10, "$lte": 12}}, true},
+		{"failing range", map[string]any{"total": map[string]any{"$gt": 10, "$lt": 12}}, false},
+		{"in", map[string]any{"status": map[string]any{"$in": []any{"open", "pending"}}}, true},
+		{"not in", map[string]any{"status": map[string]any{"$in": []any{"closed"}}}, false},
+		{"exists", map[string]any{"customer.name": map[string]any{"$exists": true}}, true},
+		{"null does not exist", map[string]any{"priority": map[string]any{"$exists": false}}, true},
+		{"or", map[string]any{"$or": []any{
+			map[string]any{"status": "closed"},
+			map[string]any{"total": map[string]any{"$gte": 12}},
+		}}, true},
+		{"failing or", map[string]any{"$or": []any{
+			map[string]any{"status": "closed"},
+			map[string]any{"total": map[string]any{"$lt": 12}},
+		}}, false},
+		{"not", map[string]any{"$not": map[string]any{"status": "open"}}, false},
+		{"and with fields", map[string]any{
+			"status": "open",
+			"$and":   []any{map[string]any{"customer.name": map[string]any{"$ne": "beta"}}},
+		}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := ParseFilterDocument(c.filter)
+			require.NoError(t, err)
+			assert.Equal(t, c.matches, f.Matches(doc))
+		})
+	}
+}
+
+func Test_Filter__shouldRejectInvalidDocumentFilters(t *testing.T) {
+	for name, doc := range map[string]map[string]any{
+		"unknown logical operator": {"$xor": []any{}},
+		"unknown field operator":   {"total": map[string]any{"$between": []any{1, 2}}},
+		"or without list":          {"$or": map[string]any{"status": "open"}},
+		"not without object":       {"$not": "open"},
+		"in without list":          {"status": map[string]any{"$in": "open"}},
+		"exists without boolean":   {"status": map[string]any{"$exists": "yes"}},
+	} {
+		_, err := ParseFilterDocument(doc)
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_Filter__shouldParseQuotedValues(t *testing.T) {
+	f, err := parseFilter(`name == "A AND B" AND a != "x==y" AND total >= 10`)
+	require.NoError(t, err)
+
+	assert.Equal(t, &Filter{Op: FilterAnd, Filters: []*Filter{
+		{Op: FilterEq, Path: []string{"name"}, Value: "A AND B"},
+		{Op: FilterNe, Path: []string{"a"}, Value: "x==y"},
+		{Op: FilterGte, Path: []string{"total"}, Value: float64(10)},
+	}}, f)
+}
+
+func Test_Filter__shouldRejectInvalidConditions(t *testing.T) {
+	for _, q := range []string{"status", `== "open"`, "status == open", `status == "open" total > 1`, `status == "open"AND total > 1`, `status == "open" AND`} {
+		_, err := parseFilter(q)
+		assert.Error(t, err, q)
+	}
+}
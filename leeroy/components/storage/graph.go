@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/shono-io/leeroy/leeroy/core"
+)
+
+// Directions in which edges are followed when traversing a graph.
+const (
+	DirectionOutbound = "outbound"
+	DirectionInbound  = "inbound"
+	DirectionAny      = "any"
+)
+
+// GraphClient is implemented by clients of stores able to relate documents through edges.
+type GraphClient interface {
+	// Link creates the edge between the vertices in the edge collection, or merges the value into it if the vertices are
+	// linked already. The edge is returned.
+	Link(ctx context.Context, collection string, from Vertex, to Vertex, value map[string]any) (map[string]any, error)
+
+	// Unlink removes the edges between the vertices from the edge collection, resulting in ErrNotFound if there are none.
+	Unlink(ctx context.Context, collection string, from Vertex, to Vertex) error
+
+	// Traverse follows the edges of the collection starting at the vertex. Each reached vertex is returned once, as an
+	// object holding the `vertex`, the `edge` it was reached through and its `depth`.
+	Traverse(ctx context.Context, collection string, start Vertex, opts TraversalOpts) (Cursor, error)
+}
+
+// Vertex identifies a document taking part in a graph.
+type Vertex struct {
+	Collection string
+	Key        string
+}
+
+// NewVertex creates a vertex for the document with the key in the collection. Without a collection, the collection is
+// derived from the concept reference like `CON#scope#code`, being the scope and the code of the concept joined by an
+// underscore.
+func NewVertex(concept string, collection string, key string) (Vertex, error) {
+	if collection == "" {
+		if concept == "" {
+			return Vertex{}, fmt.Errorf("either the collection or the concept of a vertex is required")
+		}
+
+		ref, err := core.ParseConceptReference(concept)
+		if err != nil {
+			return Vertex{}, err
+		}
+		collection = ref.Scope + "_" + ref.Code
+	}
+
+	if key == "" {
+		return Vertex{}, fmt.Errorf("key of %s vertex must not be empty", collection)
+	}
+
+	return Vertex{Collection: collection, Key: key}, nil
+}
+
+// ID returns the document handle of the vertex.
+func (v Vertex) ID() string {
+	return v.Collection + "/" + v.Key
+}
+
+type TraversalOpts struct {
+	// Direction is the direction in which to follow edges, outbound by default.
+	Direction string
+
+	// MinDepth is the depth from which vertices are returned, and MaxDepth the depth at which the traversal stops.
+	MinDepth int
+	MaxDepth int
+}
+
+func graphFields() []*service.ConfigField {
+	return []*service.ConfigField{
+		vertexField("from").
+			Description("The vertex edges start from. This is only applicable for 'link', 'unlink' and 'traverse', where it is the vertex to start the traversal at"),
+		vertexField("to").
+			Description("The vertex edges point to. This is only applicable for 'link' and 'unlink'"),
+		service.NewStringAnnotatedEnumField("direction", map[string]string{
+			DirectionOutbound: "Follow edges from their `_from` to their `_to` vertex.",
+			DirectionInbound:  "Follow edges from their `_to` to their `_from` vertex.",
+			DirectionAny:      "Follow edges in both directions.",
+		}).
+			Description("The direction in which to follow edges. This is only applicable for 'traverse'").
+			Default(DirectionOutbound),
+		service.NewIntField("min_depth").
+			Description("The depth from which reached vertices are emitted, 0 including the start vertex itself. This is only applicable for 'traverse'").
+			Default(1),
+		service.NewIntField("max_depth").
+			Description("The maximum number of edges to follow from the start vertex. This is only applicable for 'traverse'").
+			Default(1),
+	}
+}
+
+func vertexField(name string) *service.ConfigField {
+	return service.NewObjectField(name,
+		service.NewInterpolatedStringField("collection").
+			Description("The collection holding the document.").
+			Default(""),
+		service.NewInterpolatedStringField("concept").
+			Description("The reference to the concept of the document, like `CON#scope#code`, used to derive the collection when no `collection` is given. The collection is then named after the scope and the code of the concept, joined by an underscore.").
+			Default(""),
+		service.NewInterpolatedStringField("key").
+			Description("The key of the document."),
+	).
+		Optional()
+}
+
+// vertexExpr resolves a vertex from the content of a message.
+type vertexExpr struct {
+	collection *service.InterpolatedString
+	concept    *service.InterpolatedString
+	key        *service.InterpolatedString
+}
+
+func vertexExprFromConfig(conf *service.ParsedConfig, name string) (*vertexExpr, error) {
+	if !conf.Contains(name) {
+		return nil, fmt.Errorf("%s is required", name)
+	}
+
+	collection, err := conf.FieldInterpolatedString(name, "collection")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s collection: %w", name, err)
+	}
+
+	concept, err := conf.FieldInterpolatedString(name, "concept")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s concept: %w", name, err)
+	}
+
+	key, err := conf.FieldInterpolatedString(name, "key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s key: %w", name, err)
+	}
+
+	return &vertexExpr{collection: collection, concept: concept, key: key}, nil
+}
+
+func (v *vertexExpr) vertex(message *service.Message) (Vertex, error) {
+	collection, err := v.collection.TryString(message)
+	if err != nil {
+		return Vertex{}, fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	concept, err := v.concept.TryString(message)
+	if err != nil {
+		return Vertex{}, fmt.Errorf("failed to get concept: %w", err)
+	}
+
+	key, err := v.key.TryString(message)
+	if err != nil {
+		return Vertex{}, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	return NewVertex(concept, collection, key)
+}
+
+// IsGraphOperation returns whether the operation of the storage processor manipulates or traverses edges.
+func IsGraphOperation(op string) bool {
+	switch op {
+	case "link", "unlink", "traverse":
+		return true
+	default:
+		return false
+	}
+}
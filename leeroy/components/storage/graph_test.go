@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Vertex__shouldPreferExplicitCollection(t *testing.T) {
+	v, err := NewVertex("CON#crm#customer", "customers", "42")
+	require.NoError(t, err)
+	assert.Equal(t, "customers/42", v.ID())
+
+	v, err = NewVertex("", "customers", "42")
+	require.NoError(t, err)
+	assert.Equal(t, "customers/42", v.ID())
+
+	_, err = NewVertex("", "", "42")
+	assert.Error(t, err)
+}
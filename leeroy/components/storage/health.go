@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"time"
+)
+
+const (
+	healthInitialBackoff = 500 * time.Millisecond
+	healthMaxBackoff     = 10 * time.Second
+)
+
+func healthCheckField() *service.ConfigField {
+	return service.NewObjectField("health_check",
+		service.NewDurationField("startup_timeout").
+			Description("How long to wait for the store to become reachable when creating the processor, retrying with an exponential backoff. Creating the processor fails when the store remains unreachable. Set to `0s` to start without waiting.").
+			Default("0s"),
+		service.NewDurationField("interval").
+			Description("How often to check whether the store is reachable while running. Changes in health are logged and exposed through the `storage_healthy` gauge, with its `component` label holding the label of the processor, or `storage` if it has none. The store is checked right away, the gauge reporting it unhealthy until then. Set to `0s` to disable checking.").
+			Default("30s"),
+	).
+		Description("Check whether the store can be reached, both when starting and periodically while running. Processors can not take part in the readiness of a stream, so the health of the store is only logged and exposed as a metric; the `storage` output and inputs check the store when connecting instead, keeping the stream from becoming ready while the store can not be reached.").
+		Advanced()
+}
+
+// healthFromConfig waits for the store to become healthy and starts monitoring it, unless disabled.
+func healthFromConfig(conf *service.ParsedConfig, cl Client, mgr *service.Resources) (*healthMonitor, error) {
+	timeout, err := conf.FieldDuration("startup_timeout")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get health check startup timeout: %w", err)
+	}
+
+	interval, err := conf.FieldDuration("interval")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get health check interval: %w", err)
+	}
+
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := WaitHealthy(ctx, cl); err != nil {
+			return nil, err
+		}
+	}
+
+	if interval <= 0 {
+		return nil, nil
+	}
+
+	return newHealthMonitor(cl, interval, mgr), nil
+}
+
+// WaitHealthy pings the store until it can be reached, backing off exponentially between attempts. The last error is
+// returned once the context is done.
+func WaitHealthy(ctx context.Context, cl Client) error {
+	backoff := healthInitialBackoff
+
+	for {
+		err := cl.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("store did not become healthy: %w", err)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > healthMaxBackoff {
+			backoff = healthMaxBackoff
+		}
+	}
+}
+
+// healthMonitor periodically pings the store, logging changes in its health and exposing it as a gauge.
+type healthMonitor struct {
+	cl       Client
+	interval time.Duration
+	logger   *service.Logger
+	gauge    *service.MetricGauge
+	label    string
+
+	// healthy is the outcome of the last check, the store being reported unhealthy until it was checked once
+	healthy bool
+	checked bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newHealthMonitor(cl Client, interval time.Duration, mgr *service.Resources) *healthMonitor {
+	m := &healthMonitor{
+		cl:       cl,
+		interval: interval,
+		logger:   mgr.Logger(),
+		gauge:    mgr.Metrics().NewGauge("storage_healthy", "component"),
+		label:    healthLabel(mgr),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	m.gauge.Set(0, m.label)
+
+	go m.run()
+	return m
+}
+
+// healthLabel returns the value of the component label of the gauge, telling the components of a stream apart.
+func healthLabel(mgr *service.Resources) string {
+	if label := mgr.Label(); label != "" {
+		return label
+	}
+
+	return "storage"
+}
+
+func (m *healthMonitor) run() {
+	defer close(m.done)
+
+	m.check()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *healthMonitor) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+	err := m.cl.Ping(ctx)
+	cancel()
+
+	switch {
+	case err != nil && (m.healthy || !m.checked):
+		m.logger.Warnf("store became unhealthy: %v", err)
+		m.gauge.Set(0, m.label)
+	case err == nil && !m.healthy:
+		m.logger.Infof("store became healthy")
+		m.gauge.Set(1, m.label)
+	}
+
+	m.healthy, m.checked = err == nil, true
+}
+
+func (m *healthMonitor) Close() {
+	close(m.stop)
+	<-m.done
+}
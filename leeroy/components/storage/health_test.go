@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// flakyClient fails to be reached a number of times before becoming healthy.
+type flakyClient struct {
+	*MemoryClient
+	failures int
+}
+
+func (c *flakyClient) Ping(ctx context.Context) error {
+	if c.failures > 0 {
+		c.failures--
+		return fmt.Errorf("connection refused")
+	}
+
+	return nil
+}
+
+func TestWaitHealthy(t *testing.T) {
+	t.Run("should retry until the store is healthy", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+
+		cl := &flakyClient{MemoryClient: NewMemoryClient("wait_healthy"), failures: 1}
+		assert.NoError(t, WaitHealthy(tCtx, cl))
+		assert.Equal(t, 0, cl.failures)
+	})
+
+	t.Run("should give up once the context is done", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer done()
+
+		cl := &flakyClient{MemoryClient: NewMemoryClient("wait_unhealthy"), failures: 1000}
+		assert.ErrorContains(t, WaitHealthy(tCtx, cl), "connection refused")
+	})
+}
+
+func TestHealthFromConfig(t *testing.T) {
+	t.Run("should not wait for the store by default", func(t *testing.T) {
+		conf, err := service.NewConfigSpec().Field(healthCheckField()).ParseYAML("{}", nil)
+		require.NoError(t, err)
+
+		cl := &flakyClient{MemoryClient: NewMemoryClient("health_default"), failures: 1000}
+
+		monitor, err := healthFromConfig(conf.Namespace("health_check"), cl, service.MockResources())
+		require.NoError(t, err)
+		monitor.Close()
+
+		// -- the monitor checked the store once, without waiting for it
+		assert.Equal(t, 999, cl.failures)
+		assert.False(t, monitor.healthy)
+	})
+
+	t.Run("should only report a healthy store once checked", func(t *testing.T) {
+		monitor := newHealthMonitor(NewMemoryClient("health_checked"), time.Hour, service.MockResources())
+		monitor.Close()
+
+		assert.True(t, monitor.checked)
+		assert.True(t, monitor.healthy)
+	})
+}
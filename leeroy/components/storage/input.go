@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"sync"
+)
+
+func changesInputConfig() *service.ConfigSpec {
+	return service.NewConfigSpec().
+		Beta().
+		Categories("Integration").
+		Summary("Tails the changes made to the documents of a collection.").
+		Description("Emits a message per created, updated or deleted document, holding the `operation`, `collection` and `key` of the change along with the document `before` and `after` the change where known. Arangodb changes are read from the write-ahead log, which does not tell creates from updates and holds no previous documents, so every write is reported as an update. Elasticsearch is polled for documents with a newer sequence number per shard, which tells creates from updates by their version but can not observe deletes. The checkpoint of the last acknowledged change is kept in the `checkpoint_cache`, if any, to resume from after a restart. Batches which were not delivered are emitted again, holding back the checkpoint of later batches until they are.").
+		Field(DriverField()).
+		Field(service.NewStringField("collection").
+			Description("The collection to tail the changes of.")).
+		Field(service.NewStringField("checkpoint_cache").
+			Description("The cache resource to keep the checkpoint of the last acknowledged change in. Without a cache, or before the first change has been acknowledged, the changes are tailed from the moment the input connects unless `from_start` is set.").
+			Optional()).
+		Field(service.NewStringField("checkpoint_key").
+			Description("The key of the checkpoint within the cache, the collection by default.").
+			Default("")).
+		Field(service.NewBoolField("from_start").
+			Description("Start with the oldest change still known to the store when there is no checkpoint yet, instead of the changes made from now on.").
+			Default(false)).
+		Field(service.NewDurationField("poll_interval").
+			Description("How long to wait before asking the store for changes again, for drivers polling for changes.").
+			Default("1s")).
+		Field(service.NewIntField("batch_size").
+			Description("The maximum number of changes emitted within a single batch.").
+			Default(100))
+}
+
+func changesInputFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (in *changesInput, err error) {
+	in = &changesInput{mgr: mgr, logger: mgr.Logger()}
+
+	if in.collection, err = conf.FieldString("collection"); err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	if conf.Contains("checkpoint_cache") {
+		if in.cache, err = conf.FieldString("checkpoint_cache"); err != nil {
+			return nil, fmt.Errorf("failed to get checkpoint cache: %w", err)
+		}
+
+		if !mgr.HasCache(in.cache) {
+			return nil, fmt.Errorf("cache '%s' is not defined", in.cache)
+		}
+	}
+
+	if in.cacheKey, err = conf.FieldString("checkpoint_key"); err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint key: %w", err)
+	}
+	if in.cacheKey == "" {
+		in.cacheKey = in.collection
+	}
+
+	if in.opts.FromStart, err = conf.FieldBool("from_start"); err != nil {
+		return nil, fmt.Errorf("failed to get from start flag: %w", err)
+	}
+
+	if in.opts.PollInterval, err = conf.FieldDuration("poll_interval"); err != nil {
+		return nil, fmt.Errorf("failed to get poll interval: %w", err)
+	}
+
+	if in.opts.BatchSize, err = conf.FieldInt("batch_size"); err != nil {
+		return nil, fmt.Errorf("failed to get batch size: %w", err)
+	}
+	if in.opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be larger than 0")
+	}
+
+	driver, err := NewClientFromConfig(conf.Namespace("driver"), mgr)
+	if err != nil {
+		return nil, err
+	}
+	in.driver = driver
+
+	// -- release the driver when the remaining configuration turns out to be invalid
+	defer func() {
+		if err != nil {
+			_ = driver.Close()
+		}
+	}()
+
+	if _, ok := in.driver.(ChangeClient); !ok {
+		return nil, fmt.Errorf("the driver does not support tailing changes")
+	}
+
+	return in, nil
+}
+
+type changesInput struct {
+	driver     Client
+	collection string
+	cache      string
+	cacheKey   string
+	opts       ChangeOpts
+	mgr        *service.Resources
+	logger     *service.Logger
+
+	mu   sync.Mutex
+	feed ChangeFeed
+
+	// last is the checkpoint of the last change read, to resume from when reconnecting
+	last        string
+	checkpoints checkpoints
+}
+
+// Connect opens the change feed, resuming after the last change read or the checkpoint held by the cache.
+func (i *changesInput) Connect(ctx context.Context) error {
+	// -- keep the stream from becoming ready while the store can not be reached
+	if err := i.driver.Ping(ctx); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	opts := i.opts
+	opts.Checkpoint = i.last
+
+	if opts.Checkpoint == "" && i.cache != "" {
+		cp, err := i.readCheckpoint(ctx)
+		if err != nil {
+			return err
+		}
+		opts.Checkpoint = cp
+	}
+
+	feed, err := i.driver.(ChangeClient).Changes(ctx, i.collection, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open the changes of %s: %w", i.collection, err)
+	}
+
+	i.feed = feed
+	return nil
+}
+
+func (i *changesInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	i.mu.Lock()
+	feed := i.feed
+	i.mu.Unlock()
+
+	if feed == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	changes, err := feed.Next(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		// -- have benthos reconnect, resuming after the last change read
+		i.logger.Warnf("failed to read the changes of %s: %v", i.collection, err)
+		i.closeFeed()
+		return nil, nil, service.ErrNotConnected
+	}
+
+	var batch service.MessageBatch
+	for _, change := range changes {
+		batch = append(batch, changeMessage(change))
+	}
+
+	last := changes[len(changes)-1].Checkpoint
+	i.mu.Lock()
+	i.last = last
+	i.mu.Unlock()
+
+	// -- nacked batches are redelivered by the registered input, so this is only called once a batch got delivered
+	pending := i.checkpoints.add(last)
+	return batch, func(ctx context.Context, err error) error {
+		if cp := i.checkpoints.ack(pending); cp != "" && i.cache != "" {
+			return i.writeCheckpoint(ctx, cp)
+		}
+
+		return nil
+	}, nil
+}
+
+func changeMessage(change Change) *service.Message {
+	payload := map[string]any{
+		"operation":  change.Operation,
+		"collection": change.Collection,
+		"key":        change.Key,
+	}
+	if change.Before != nil {
+		payload["before"] = change.Before
+	}
+	if change.After != nil {
+		payload["after"] = change.After
+	}
+
+	result := service.NewMessage(nil)
+	result.SetStructuredMut(payload)
+	result.MetaSetMut("storage_change", change.Operation)
+	result.MetaSetMut("storage_collection", change.Collection)
+	result.MetaSetMut("storage_key", change.Key)
+	result.MetaSetMut("storage_checkpoint", change.Checkpoint)
+
+	return result
+}
+
+func (i *changesInput) readCheckpoint(ctx context.Context) (result string, err error) {
+	cerr := i.mgr.AccessCache(ctx, i.cache, func(c service.Cache) {
+		var b []byte
+		b, err = c.Get(ctx, i.cacheKey)
+		result = string(b)
+	})
+	if cerr != nil {
+		return "", fmt.Errorf("failed to access checkpoint cache: %w", cerr)
+	}
+
+	if errors.Is(err, service.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return result, nil
+}
+
+func (i *changesInput) writeCheckpoint(ctx context.Context, checkpoint string) (err error) {
+	cerr := i.mgr.AccessCache(ctx, i.cache, func(c service.Cache) {
+		err = c.Set(ctx, i.cacheKey, []byte(checkpoint), nil)
+	})
+	if cerr != nil {
+		return fmt.Errorf("failed to access checkpoint cache: %w", cerr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func (i *changesInput) closeFeed() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.feed != nil {
+		if err := i.feed.Close(); err != nil {
+			i.logger.Warnf("failed to close the changes of %s: %v", i.collection, err)
+		}
+		i.feed = nil
+	}
+}
+
+func (i *changesInput) Close(ctx context.Context) error {
+	i.closeFeed()
+	return i.driver.Close()
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/bloblang"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"sync"
+)
+
+func listInputConfig() *service.ConfigSpec {
+	return service.NewConfigSpec().
+		Beta().
+		Categories("Integration").
+		Summary("Walks the documents of a collection, emitting a message per document.").
+		Description("Documents are read from the store a page at a time and emitted in batches as they are read, so walking a collection of millions of documents only holds a page and a batch in memory at once, unlike the 'list' operation of the `storage` processor. Each message carries the `storage_collection` and `storage_position` metadata, along with `storage_score` when the driver scored the document. The input ends once all documents have been read. When reading from the store fails midway, the walk is started over, emitting the documents read before the failure again.").
+		Field(DriverField()).
+		Field(service.NewStringField("collection").
+			Description("The collection to walk.")).
+		Field(service.NewStringField("q").
+			Description("The native query selecting the documents to walk. Prefer `filter` unless the query can not be expressed as a filter.").
+			Optional()).
+		Field(service.NewBloblangField("filter").
+			Description("A mapping resulting in a driver neutral filter document selecting the documents to walk, as described for the `storage` processor. The mapping is executed once, against an empty message. This can not be combined with `q`").
+			Example(`root.status = "open"`).
+			Optional()).
+		Field(service.NewStringField("sort").
+			Description("A comma separated list of fields to order the documents by, each optionally followed by `:asc` or `:desc`.").
+			Example("created_at:desc,name").
+			Default("")).
+		Field(service.NewIntField("page_size").
+			Description("The number of documents to fetch from the store per round trip.").
+			Default(100)).
+		Field(service.NewIntField("batch_size").
+			Description("The maximum number of documents emitted within a single batch.").
+			Default(100)).
+		Field(service.NewBoolField("enable_pit").
+			Description("Walk a point in time of the collection, for drivers supporting it.").
+			Default(false)).
+		Field(service.NewStringField("pit_keep_alive").
+			Description("How long a point in time is kept alive between fetching two pages. This is only applicable with `enable_pit` set").
+			Default("1m"))
+}
+
+func listInputFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (in *listInput, err error) {
+	in = &listInput{paging: &PagingOpts{}, logger: mgr.Logger()}
+
+	if in.collection, err = conf.FieldString("collection"); err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+
+	if conf.Contains("q") {
+		if in.q, err = conf.FieldString("q"); err != nil {
+			return nil, fmt.Errorf("failed to get query: %w", err)
+		}
+	}
+
+	var filter *bloblang.Executor
+	if conf.Contains("filter") {
+		if conf.Contains("q") {
+			return nil, fmt.Errorf("only one of q and filter can be specified")
+		}
+
+		if filter, err = conf.FieldBloblang("filter"); err != nil {
+			return nil, fmt.Errorf("failed to get filter: %w", err)
+		}
+	}
+
+	sort, err := conf.FieldString("sort")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sort: %w", err)
+	}
+	if in.paging.Sort, err = ParseSort(sort); err != nil {
+		return nil, err
+	}
+
+	pageSize, err := conf.FieldInt("page_size")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page size: %w", err)
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be larger than 0")
+	}
+	in.paging.PageSize = int64(pageSize)
+
+	if in.batchSize, err = conf.FieldInt("batch_size"); err != nil {
+		return nil, fmt.Errorf("failed to get batch size: %w", err)
+	}
+	if in.batchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be larger than 0")
+	}
+
+	if in.pit, err = conf.FieldBool("enable_pit"); err != nil {
+		return nil, fmt.Errorf("failed to get enable_pit flag: %w", err)
+	}
+
+	if in.paging.KeepAlive, err = conf.FieldString("pit_keep_alive"); err != nil {
+		return nil, fmt.Errorf("failed to get pit keep alive: %w", err)
+	}
+
+	driver, err := NewClientFromConfig(conf.Namespace("driver"), mgr)
+	if err != nil {
+		return nil, err
+	}
+	in.driver = driver
+
+	// -- release the driver when the remaining configuration turns out to be invalid
+	defer func() {
+		if err != nil {
+			_ = driver.Close()
+		}
+	}()
+
+	if filter != nil {
+		if in.filter, err = filterQuery(filter, service.NewMessage(nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	return in, nil
+}
+
+type listInput struct {
+	driver     Client
+	collection string
+	q          string
+	filter     *Filter
+	paging     *PagingOpts
+	pit        bool
+	batchSize  int
+	logger     *service.Logger
+
+	mu       sync.Mutex
+	cur      Cursor
+	position int
+	done     bool
+}
+
+// Connect opens the cursor walking the collection, unless all documents have been read already.
+func (i *listInput) Connect(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cur != nil || i.done {
+		return nil
+	}
+
+	// -- keep the stream from becoming ready while the store can not be reached
+	if err := i.driver.Ping(ctx); err != nil {
+		return err
+	}
+
+	var qry any = i.q
+	if i.filter != nil {
+		qry = i.filter
+	} else if i.pit {
+		parsed, err := i.driver.ParseQuery(i.q)
+		if err != nil {
+			return fmt.Errorf("failed to parse query: %w", err)
+		}
+		qry = parsed
+	}
+
+	cur, err := i.driver.List(ctx, i.collection, qry, i.pit, i.paging)
+	if err != nil {
+		return fmt.Errorf("failed to list the documents of %s: %w", i.collection, err)
+	}
+
+	i.cur, i.position = cur, 0
+	return nil
+}
+
+// ReadBatch emits the next documents read from the cursor, ending the input once the cursor is exhausted.
+func (i *listInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.done {
+		return nil, nil, service.ErrEndOfInput
+	}
+	if i.cur == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	var batch service.MessageBatch
+	for len(batch) < i.batchSize && i.cur.HasNext() {
+		doc, err := i.cur.Read()
+		if err != nil {
+			// -- the cursor can not be trusted after failing, so the walk is started over when reconnecting
+			i.logger.Errorf("failed to read document of %s at position %d: %v", i.collection, i.position, err)
+			i.closeCursor()
+			return nil, nil, service.ErrNotConnected
+		}
+
+		result := service.NewMessage(nil)
+		result.SetStructured(doc)
+		setListMeta(result, i.collection, i.position, doc)
+
+		batch = append(batch, result)
+		i.position++
+	}
+
+	if len(batch) == 0 {
+		i.closeCursor()
+		i.done = true
+		return nil, nil, service.ErrEndOfInput
+	}
+
+	return batch, func(ctx context.Context, err error) error {
+		return nil
+	}, nil
+}
+
+func (i *listInput) closeCursor() {
+	if i.cur == nil {
+		return
+	}
+
+	if err := i.cur.Close(); err != nil {
+		i.logger.Warnf("failed to close the cursor on %s: %v", i.collection, err)
+	}
+	i.cur = nil
+}
+
+func (i *listInput) Close(ctx context.Context) error {
+	i.mu.Lock()
+	i.closeCursor()
+	i.mu.Unlock()
+
+	return i.driver.Close()
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestListInput(t *testing.T, yaml string) *listInput {
+	t.Helper()
+
+	conf, err := listInputConfig().ParseYAML(strings.TrimSpace(yaml), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	in, err := listInputFromConfig(conf, service.MockResources())
+	require.NoError(t, err)
+
+	return in
+}
+
+func TestListInput(t *testing.T) {
+	t.Run("should walk the collection in batches", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		cl := NewMemoryClient("list_walk")
+		for i, name := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, cl.Set(tCtx, "concepts", name, map[string]any{"name": name, "open": i%2 == 0}))
+		}
+
+		in := newTestListInput(t, `
+driver:
+  memory:
+    name: list_walk
+collection: concepts
+filter: root.open = true
+sort: name:desc
+batch_size: 2
+`)
+		require.NoError(t, in.Connect(tCtx))
+		defer in.Close(tCtx)
+
+		var names []any
+		var positions []any
+		for {
+			batch, ack, err := in.ReadBatch(tCtx)
+			if err == service.ErrEndOfInput {
+				break
+			}
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(batch), 2)
+			require.NoError(t, ack(tCtx, nil))
+
+			for _, msg := range batch {
+				doc, err := msg.AsStructured()
+				require.NoError(t, err)
+				names = append(names, doc.(map[string]any)["name"])
+
+				pos, _ := msg.MetaGetMut("storage_position")
+				positions = append(positions, pos)
+			}
+		}
+
+		assert.Equal(t, []any{"e", "c", "a"}, names)
+		assert.Equal(t, []any{0, 1, 2}, positions)
+
+		// -- the walk is not started over when reconnecting
+		require.NoError(t, in.Connect(tCtx))
+		_, _, err := in.ReadBatch(tCtx)
+		assert.ErrorIs(t, err, service.ErrEndOfInput)
+	})
+
+	t.Run("should start over after failing to read", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		cl := NewMemoryClient("list_failing")
+		for _, name := range []string{"a", "b", "c"} {
+			require.NoError(t, cl.Set(tCtx, "concepts", name, map[string]any{"name": name}))
+		}
+
+		in := newTestListInput(t, `
+driver:
+  memory:
+    name: list_failing
+collection: concepts
+sort: name
+`)
+		in.driver = &failingListClient{MemoryClient: cl, failures: 1}
+		require.NoError(t, in.Connect(tCtx))
+		defer in.Close(tCtx)
+
+		_, _, err := in.ReadBatch(tCtx)
+		assert.ErrorIs(t, err, service.ErrNotConnected)
+
+		require.NoError(t, in.Connect(tCtx))
+		batch, _, err := in.ReadBatch(tCtx)
+		require.NoError(t, err)
+		require.Len(t, batch, 3)
+
+		pos, _ := batch[0].MetaGetMut("storage_position")
+		assert.Equal(t, 0, pos)
+	})
+
+	t.Run("should reject both a query and a filter", func(t *testing.T) {
+		conf, err := listInputConfig().ParseYAML(strings.TrimSpace(`
+driver:
+  memory: {}
+collection: concepts
+q: open == true
+filter: root.open = true
+`), service.GlobalEnvironment())
+		require.NoError(t, err)
+
+		_, err = listInputFromConfig(conf, service.MockResources())
+		assert.Error(t, err)
+	})
+}
+
+// failingListClient lists documents through cursors failing on their second read, as many times as there are failures.
+type failingListClient struct {
+	*MemoryClient
+	failures int
+}
+
+func (c *failingListClient) List(ctx context.Context, collection string, q any, pit bool, paging *PagingOpts) (Cursor, error) {
+	cur, err := c.MemoryClient.List(ctx, collection, q, pit, paging)
+	if err != nil || c.failures == 0 {
+		return cur, err
+	}
+
+	c.failures--
+	return &failingCursor{Cursor: cur, reads: 1}, nil
+}
+
+type failingCursor struct {
+	Cursor
+	reads int
+}
+
+func (c *failingCursor) Read() (map[string]any, error) {
+	if c.reads == 0 {
+		return nil, fmt.Errorf("connection reset")
+	}
+
+	c.reads--
+	return c.Cursor.Read()
+}
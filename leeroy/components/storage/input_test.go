@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestChangesInput(t *testing.T, mgr *service.Resources, yaml string) *changesInput {
+	t.Helper()
+
+	conf, err := changesInputConfig().ParseYAML(strings.TrimSpace(yaml), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	in, err := changesInputFromConfig(conf, mgr)
+	require.NoError(t, err)
+
+	return in
+}
+
+func TestChangesInput(t *testing.T) {
+	t.Run("should emit the changes made to the collection", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		cl := NewMemoryClient("changes_emit")
+		require.NoError(t, cl.Set(tCtx, "concepts", "a", map[string]any{"name": "before"}))
+
+		in := newTestChangesInput(t, service.MockResources(), `
+driver:
+  memory:
+    name: changes_emit
+collection: concepts
+`)
+		require.NoError(t, in.Connect(tCtx))
+		defer in.Close(tCtx)
+
+		require.NoError(t, cl.Set(tCtx, "others", "x", map[string]any{}))
+		require.NoError(t, cl.Set(tCtx, "concepts", "b", map[string]any{"name": "beta"}))
+		_, err := cl.Merge(tCtx, "concepts", "b", map[string]any{"name": "bravo"})
+		require.NoError(t, err)
+		require.NoError(t, cl.Delete(tCtx, "concepts", "b"))
+
+		batch, ack, err := in.ReadBatch(tCtx)
+		require.NoError(t, err)
+		require.Len(t, batch, 3)
+		require.NoError(t, ack(tCtx, nil))
+
+		var payloads []any
+		for _, msg := range batch {
+			p, err := msg.AsStructured()
+			require.NoError(t, err)
+			payloads = append(payloads, p)
+		}
+
+		assert.Equal(t, []any{
+			map[string]any{"operation": "create", "collection": "concepts", "key": "b", "after": map[string]any{"name": "beta"}},
+			map[string]any{"operation": "update", "collection": "concepts", "key": "b", "before": map[string]any{"name": "beta"}, "after": map[string]any{"name": "bravo"}},
+			map[string]any{"operation": "delete", "collection": "concepts", "key": "b", "before": map[string]any{"name": "bravo"}},
+		}, payloads)
+
+		op, _ := batch[2].MetaGetMut("storage_change")
+		assert.Equal(t, "delete", op)
+	})
+
+	t.Run("should resume after the acknowledged checkpoint", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		cl := NewMemoryClient("changes_resume")
+		mgr := service.MockResources(service.MockResourcesOptAddCache("checkpoints"))
+		config := `
+driver:
+  memory:
+    name: changes_resume
+collection: concepts
+checkpoint_cache: checkpoints
+batch_size: 1
+`
+
+		in := newTestChangesInput(t, mgr, config)
+		require.NoError(t, in.Connect(tCtx))
+
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, cl.Set(tCtx, "concepts", key, map[string]any{}))
+		}
+
+		var acks []service.AckFunc
+		for range []string{"a", "b", "c"} {
+			_, ack, err := in.ReadBatch(tCtx)
+			require.NoError(t, err)
+			acks = append(acks, ack)
+		}
+
+		// -- the change to c can not be committed before the one to b
+		require.NoError(t, acks[0](tCtx, nil))
+		require.NoError(t, acks[2](tCtx, nil))
+		require.NoError(t, in.Close(tCtx))
+
+		in = newTestChangesInput(t, mgr, config)
+		require.NoError(t, in.Connect(tCtx))
+		defer in.Close(tCtx)
+
+		batch, _, err := in.ReadBatch(tCtx)
+		require.NoError(t, err)
+		require.Len(t, batch, 1)
+
+		key, _ := batch[0].MetaGetMut("storage_key")
+		assert.Equal(t, "b", key)
+	})
+
+	t.Run("should redeliver nacked changes before committing later ones", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		cl := NewMemoryClient("changes_nack")
+		mgr := service.MockResources(service.MockResourcesOptAddCache("checkpoints"))
+
+		in := newTestChangesInput(t, mgr, `
+driver:
+  memory:
+    name: changes_nack
+collection: concepts
+checkpoint_cache: checkpoints
+batch_size: 1
+`)
+		retrying := service.AutoRetryNacksBatched(in)
+		require.NoError(t, retrying.Connect(tCtx))
+		defer retrying.Close(tCtx)
+
+		for _, key := range []string{"a", "b"} {
+			require.NoError(t, cl.Set(tCtx, "concepts", key, map[string]any{}))
+		}
+
+		_, nack, err := retrying.ReadBatch(tCtx)
+		require.NoError(t, err)
+		second, ack, err := retrying.ReadBatch(tCtx)
+		require.NoError(t, err)
+
+		require.NoError(t, nack(tCtx, errors.New("delivery failed")))
+		require.NoError(t, ack(tCtx, nil))
+
+		// -- the change to b can not be committed while the one to a is not delivered
+		cp, err := in.readCheckpoint(tCtx)
+		require.NoError(t, err)
+		assert.Empty(t, cp)
+
+		redelivered, ack, err := retrying.ReadBatch(tCtx)
+		require.NoError(t, err)
+		require.Len(t, redelivered, 1)
+
+		key, _ := redelivered[0].MetaGetMut("storage_key")
+		assert.Equal(t, "a", key)
+		require.NoError(t, ack(tCtx, nil))
+
+		expected, _ := second[0].MetaGetMut("storage_checkpoint")
+		cp, err = in.readCheckpoint(tCtx)
+		require.NoError(t, err)
+		assert.Equal(t, expected, cp)
+	})
+
+	t.Run("should reject drivers unable to tail changes", func(t *testing.T) {
+		conf, err := changesInputConfig().ParseYAML(strings.TrimSpace(`
+driver:
+  postgres:
+    dsn: postgres://localhost/test
+collection: concepts
+`), service.GlobalEnvironment())
+		require.NoError(t, err)
+
+		_, err = changesInputFromConfig(conf, service.MockResources())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func init() {
+	if err := RegisterDriver("memory", MemoryConfigFields(), NewMemoryClientFromConfig); err != nil {
+		panic(err)
+	}
+}
+
+// memoryMaxChanges is the number of changes a store keeps for its change feeds.
+const memoryMaxChanges = 10000
+
+var (
+	memoryStoresMu sync.Mutex
+	memoryStores   = map[string]*memoryStore{}
+)
+
+func MemoryConfigFields() []*service.ConfigField {
+	return []*service.ConfigField{
+		service.NewStringField("name").
+			Description("The name of the in-memory store. Components referring to the same name share the same documents.").
+			Default("default"),
+	}
+}
+
+func NewMemoryClientFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (Client, error) {
+	name, err := conf.FieldString("name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get name field: %w", err)
+	}
+
+	return NewMemoryClient(name), nil
+}
+
+// NewMemoryClient returns a client for the named in-memory store, creating the store if it does not exist yet.
+func NewMemoryClient(name string) *MemoryClient {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+
+	st, fnd := memoryStores[name]
+	if !fnd {
+		st = &memoryStore{collections: map[string]map[string]*memoryDocument{}}
+		memoryStores[name] = st
+	}
+
+	return &MemoryClient{store: st}
+}
+
+type memoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]*memoryDocument
+
+	// revision is the last revision handed out to a document
+	revision uint64
+
+	// changes holds the last changes made since the first change feed was opened on the store, notifying waiting feeds
+	// by closing the changed channel. dropped is the number of older changes which are no longer kept.
+	tracking bool
+	changes  []Change
+	dropped  int
+	changed  chan struct{}
+}
+
+type memoryDocument struct {
+	doc      map[string]any
+	revision string
+}
+
+// MemoryClient is a storage client keeping all documents in memory. It is meant for testing pipelines and running
+// them locally without an external store.
+type MemoryClient struct {
+	store *memoryStore
+}
+
+func (c *MemoryClient) ParseQuery(config string) (any, error) {
+	return parseFilter(config)
+}
+
+func (c *MemoryClient) List(ctx context.Context, collection string, q any, pitEnabled bool, paging *PagingOpts) (Cursor, error) {
+	var flt *Filter
+	switch qt := q.(type) {
+	case nil:
+	case string:
+		f, err := parseFilter(qt)
+		if err != nil {
+			return nil, err
+		}
+		flt = f
+	case *Filter:
+		flt = qt
+	default:
+		return nil, fmt.Errorf("query is not a valid memory query")
+	}
+
+	c.store.mu.RLock()
+	col := c.store.collections[collection]
+	keys := make([]string, 0, len(col))
+	for k := range col {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var docs []map[string]any
+	for _, k := range keys {
+		if flt.Matches(col[k].doc) {
+			docs = append(docs, copyDocument(col[k].doc))
+		}
+	}
+	c.store.mu.RUnlock()
+
+	if paging != nil && len(paging.Sort) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			return lessBySort(docs[i], docs[j], paging.Sort)
+		})
+	}
+
+	if paging != nil {
+		if paging.Offset >= int64(len(docs)) {
+			docs = nil
+		} else {
+			docs = docs[paging.Offset:]
+		}
+
+		if paging.Size > 0 && paging.Size < int64(len(docs)) {
+			docs = docs[:paging.Size]
+		}
+	}
+
+	return &memoryCursor{docs: docs}, nil
+}
+
+func (c *MemoryClient) Get(ctx context.Context, collection string, key string) (map[string]any, error) {
+	doc, _, err := c.GetRevision(ctx, collection, key)
+	return doc, err
+}
+
+func (c *MemoryClient) GetRevision(ctx context.Context, collection string, key string) (map[string]any, string, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	md, fnd := c.store.collections[collection][key]
+	if !fnd {
+		return nil, "", ErrNotFound
+	}
+
+	return copyDocument(md.doc), md.revision, nil
+}
+
+func (c *MemoryClient) Set(ctx context.Context, collection string, key string, value map[string]any) error {
+	_, err := c.SetRevision(ctx, collection, key, value, "")
+	return err
+}
+
+func (c *MemoryClient) SetRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (string, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if err := c.checkRevision(collection, key, expected); err != nil {
+		return "", err
+	}
+
+	return c.put(collection, key, copyDocument(value)), nil
+}
+
+func (c *MemoryClient) Merge(ctx context.Context, collection string, key string, value map[string]any) (map[string]any, error) {
+	merged, _, err := c.MergeRevision(ctx, collection, key, value, "")
+	return merged, err
+}
+
+func (c *MemoryClient) MergeRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (map[string]any, string, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if err := c.checkRevision(collection, key, expected); err != nil {
+		return nil, "", err
+	}
+
+	doc := map[string]any{}
+	if md, fnd := c.store.collections[collection][key]; fnd {
+		// -- keep the stored document intact, it is the previous version of the document now
+		doc = copyDocument(md.doc)
+	}
+
+	merged := mergeDocuments(doc, copyDocument(value))
+	rev := c.put(collection, key, merged)
+
+	return copyDocument(merged), rev, nil
+}
+
+func (c *MemoryClient) Add(ctx context.Context, collection string, key string, value map[string]any) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if _, fnd := c.store.collections[collection][key]; fnd {
+		return service.ErrKeyAlreadyExists
+	}
+
+	c.put(collection, key, copyDocument(value))
+	return nil
+}
+
+func (c *MemoryClient) Delete(ctx context.Context, collection string, key string) error {
+	return c.DeleteRevision(ctx, collection, key, "")
+}
+
+func (c *MemoryClient) DeleteRevision(ctx context.Context, collection string, key string, expected string) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if _, fnd := c.store.collections[collection][key]; !fnd {
+		return ErrNotFound
+	}
+
+	if err := c.checkRevision(collection, key, expected); err != nil {
+		return err
+	}
+
+	c.remove(collection, key)
+	return nil
+}
+
+func (c *MemoryClient) PurgeExpired(ctx context.Context, collection string, path []string, now time.Time) (int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	var result int64
+	for key, md := range c.store.collections[collection] {
+		if v, fnd := lookupPath(md.doc, path); fnd && expired(v, now) {
+			c.remove(collection, key)
+			result++
+		}
+	}
+
+	return result, nil
+}
+
+func (c *MemoryClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *MemoryClient) Close() error {
+	return nil
+}
+
+// checkRevision verifies the document is at the expected revision, if any. The store lock must be held.
+func (c *MemoryClient) checkRevision(collection string, key string, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	md, fnd := c.store.collections[collection][key]
+	if !fnd {
+		return ErrNotFound
+	}
+
+	if md.revision != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrRevisionMismatch, expected, md.revision)
+	}
+
+	return nil
+}
+
+// put stores the document under a new revision, creating the collection when needed. The store lock must be held.
+func (c *MemoryClient) put(collection string, key string, doc map[string]any) string {
+	col, fnd := c.store.collections[collection]
+	if !fnd {
+		col = map[string]*memoryDocument{}
+		c.store.collections[collection] = col
+	}
+
+	c.store.revision++
+	rev := strconv.FormatUint(c.store.revision, 10)
+
+	if prev, fnd := col[key]; fnd {
+		c.record(Change{Operation: ChangeUpdate, Collection: collection, Key: key, Before: prev.doc, After: doc})
+	} else {
+		c.record(Change{Operation: ChangeCreate, Collection: collection, Key: key, After: doc})
+	}
+
+	col[key] = &memoryDocument{doc: doc, revision: rev}
+	return rev
+}
+
+// remove deletes the document. The store lock must be held.
+func (c *MemoryClient) remove(collection string, key string) {
+	if prev, fnd := c.store.collections[collection][key]; fnd {
+		delete(c.store.collections[collection], key)
+		c.record(Change{Operation: ChangeDelete, Collection: collection, Key: key, Before: prev.doc})
+	}
+}
+
+// record keeps the change for the change feeds, if any were opened. The store lock must be held.
+func (c *MemoryClient) record(change Change) {
+	if !c.store.tracking {
+		return
+	}
+
+	change.Checkpoint = strconv.Itoa(c.store.dropped + len(c.store.changes) + 1)
+	c.store.changes = append(c.store.changes, change)
+
+	// -- keep the log from growing for as long as the process runs
+	if over := len(c.store.changes) - memoryMaxChanges; over > 0 {
+		c.store.changes = c.store.changes[over:]
+		c.store.dropped += over
+	}
+
+	close(c.store.changed)
+	c.store.changed = make(chan struct{})
+}
+
+// Changes returns the changes made to the collection. The store only keeps track of changes once the first feed has been
+// opened on it, so starting from the start replays the changes made since then. Only the last memoryMaxChanges changes
+// are kept, so feeds falling further behind miss the older ones.
+func (c *MemoryClient) Changes(ctx context.Context, collection string, opts ChangeOpts) (ChangeFeed, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if !c.store.tracking {
+		c.store.tracking = true
+		c.store.changed = make(chan struct{})
+	}
+
+	feed := &memoryChangeFeed{store: c.store, collection: collection, batchSize: opts.BatchSize}
+	switch {
+	case opts.Checkpoint != "":
+		pos, err := strconv.Atoi(opts.Checkpoint)
+		if err != nil || pos < 0 {
+			return nil, fmt.Errorf("invalid checkpoint %q", opts.Checkpoint)
+		}
+		feed.position = pos
+	case !opts.FromStart:
+		feed.position = c.store.dropped + len(c.store.changes)
+	}
+
+	return feed, nil
+}
+
+// lessBySort reports whether document a sorts before document b. Documents missing a field sort after documents having
+// it, regardless of the order.
+func lessBySort(a, b map[string]any, sorting []SortField) bool {
+	for _, sf := range sorting {
+		av, aFnd := lookupPath(a, sf.Path)
+		bv, bFnd := lookupPath(b, sf.Path)
+
+		switch {
+		case !aFnd && !bFnd:
+			continue
+		case !bFnd:
+			return true
+		case !aFnd:
+			return false
+		}
+
+		cmp, ok := compareValues(av, bv)
+		if !ok || cmp == 0 {
+			continue
+		}
+
+		if sf.Descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+
+	return false
+}
+
+type memoryChangeFeed struct {
+	store      *memoryStore
+	collection string
+	batchSize  int
+
+	// position is the number of changes of the store which have been passed, including the ones no longer kept
+	position int
+}
+
+func (f *memoryChangeFeed) Next(ctx context.Context) ([]Change, error) {
+	for {
+		f.store.mu.RLock()
+		if f.position < f.store.dropped {
+			f.position = f.store.dropped
+		}
+
+		var result []Change
+		for f.position-f.store.dropped < len(f.store.changes) && (f.batchSize <= 0 || len(result) < f.batchSize) {
+			change := f.store.changes[f.position-f.store.dropped]
+			f.position++
+
+			if change.Collection == f.collection {
+				change.Before, change.After = copyDocument(change.Before), copyDocument(change.After)
+				result = append(result, change)
+			}
+		}
+		changed := f.store.changed
+		f.store.mu.RUnlock()
+
+		if len(result) > 0 {
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (f *memoryChangeFeed) Close() error {
+	return nil
+}
+
+type memoryCursor struct {
+	docs   []map[string]any
+	offset int
+}
+
+func (c *memoryCursor) HasNext() bool {
+	return c.offset < len(c.docs)
+}
+
+func (c *memoryCursor) Read() (map[string]any, error) {
+	if !c.HasNext() {
+		return nil, fmt.Errorf("no more documents")
+	}
+
+	doc := c.docs[c.offset]
+	c.offset++
+
+	return doc, nil
+}
+
+func (c *memoryCursor) Close() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_MemoryClient__shouldCapChanges(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	cl := NewMemoryClient("changes_capped")
+	feed, err := cl.Changes(tCtx, "concepts", ChangeOpts{FromStart: true, BatchSize: 1})
+	require.NoError(t, err)
+	defer feed.Close()
+
+	for i := 0; i < memoryMaxChanges+5; i++ {
+		require.NoError(t, cl.Set(tCtx, "concepts", strconv.Itoa(i), map[string]any{}))
+	}
+	assert.Len(t, cl.store.changes, memoryMaxChanges)
+
+	// -- the feed fell behind, so it continues with the oldest change kept
+	changes, err := feed.Next(tCtx)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "5", changes[0].Key)
+	assert.Equal(t, "6", changes[0].Checkpoint)
+
+	resumed, err := cl.Changes(tCtx, "concepts", ChangeOpts{Checkpoint: changes[0].Checkpoint, BatchSize: 1})
+	require.NoError(t, err)
+
+	changes, err = resumed.Next(tCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "6", changes[0].Key)
+}
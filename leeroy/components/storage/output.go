@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+)
+
+func storeOutputConfig() *service.ConfigSpec {
+	return service.NewConfigSpec().
+		Beta().
+		Categories("Integration").
+		Summary("Persists the documents held by messages using one of the storage drivers.").
+		Description("Messages are written per batch, applying 'set', 'add' and 'delete' in as few requests to the store as the driver allows. Messages which could not be written are nacked and retried by benthos, so writes failing for good, like adding an existing document, should be routed elsewhere using a `fallback` or `reject_errored` output. Deleting a document which does not exist succeeds. The output only connects once the store can be reached, and reconnects when a batch could not be written because the store became unreachable, keeping the stream from being ready in the meantime.").
+		Field(DriverField()).
+		Field(service.NewInterpolatedStringField("collection").
+			Description("The collection to write the documents to.")).
+		Field(service.NewStringAnnotatedEnumField("operation", map[string]string{
+			"set":    "Create the document or replace it if it exists.",
+			"add":    "Create the document, failing if it exists.",
+			"merge":  "Merge the document into the existing one, creating it if it does not exist.",
+			"delete": "Remove the document.",
+		}).
+			Description("The operation to apply for each message.").
+			Default("set")).
+		Field(service.NewInterpolatedStringField("key").
+			Description("The key of the document.")).
+		Field(service.NewOutputMaxInFlightField()).
+		Field(service.NewBatchPolicyField("batching"))
+}
+
+func outputFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (out *storeOutput, batching service.BatchPolicy, maxInFlight int, err error) {
+	out = &storeOutput{}
+
+	if out.collection, err = conf.FieldInterpolatedString("collection"); err != nil {
+		return nil, batching, 0, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	if out.operation, err = conf.FieldString("operation"); err != nil {
+		return nil, batching, 0, fmt.Errorf("failed to get operation: %w", err)
+	}
+
+	if out.key, err = conf.FieldInterpolatedString("key"); err != nil {
+		return nil, batching, 0, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	if maxInFlight, err = conf.FieldMaxInFlight(); err != nil {
+		return nil, batching, 0, fmt.Errorf("failed to get max in flight: %w", err)
+	}
+
+	if batching, err = conf.FieldBatchPolicy("batching"); err != nil {
+		return nil, batching, 0, fmt.Errorf("failed to get batching policy: %w", err)
+	}
+
+	if out.driver, err = NewClientFromConfig(conf.Namespace("driver"), mgr); err != nil {
+		return nil, batching, 0, err
+	}
+
+	return out, batching, maxInFlight, nil
+}
+
+type storeOutput struct {
+	driver     Client
+	collection *service.InterpolatedString
+	operation  string
+	key        *service.InterpolatedString
+}
+
+// Connect checks the store can be reached, which keeps the stream from becoming ready until it can.
+func (o *storeOutput) Connect(ctx context.Context) error {
+	return o.driver.Ping(ctx)
+}
+
+// WriteBatch writes the documents of the batch, reporting the messages which failed so only those are retried.
+func (o *storeOutput) WriteBatch(ctx context.Context, batch service.MessageBatch) error {
+	var batchErr *service.BatchError
+	failed := func(i int, err error) {
+		if batchErr == nil {
+			batchErr = service.NewBatchError(batch, err)
+		}
+		batchErr.Failed(i, err)
+	}
+
+	var ops []BulkOperation
+	var indexes []int
+	for i := range batch {
+		op, err := o.operationFor(batch, i)
+		if err != nil {
+			failed(i, err)
+			continue
+		}
+
+		if o.operation == "merge" {
+			if _, err := o.driver.Merge(ctx, op.Collection, op.Key, op.Value); err != nil {
+				failed(i, fmt.Errorf("unable to merge document with key %s: %w", op.Key, err))
+			}
+			continue
+		}
+
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	if len(ops) > 0 {
+		errs, err := Bulk(ctx, o.driver, ops)
+		if err != nil {
+			// -- have benthos reconnect, marking the stream as not ready until the store can be reached again
+			if perr := o.driver.Ping(ctx); perr != nil {
+				return service.ErrNotConnected
+			}
+
+			return fmt.Errorf("unable to apply bulk %s: %w", o.operation, err)
+		}
+
+		for j, err := range errs {
+			// -- the document is gone either way
+			if err == nil || (o.operation == "delete" && errors.Is(err, ErrNotFound)) {
+				continue
+			}
+
+			failed(indexes[j], fmt.Errorf("unable to %s document with key %s: %w", o.operation, ops[j].Key, err))
+		}
+	}
+
+	if batchErr != nil {
+		return batchErr
+	}
+
+	return nil
+}
+
+func (o *storeOutput) operationFor(batch service.MessageBatch, i int) (BulkOperation, error) {
+	key, err := batch.TryInterpolatedString(i, o.key)
+	if err != nil {
+		return BulkOperation{}, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	col, err := batch.TryInterpolatedString(i, o.collection)
+	if err != nil {
+		return BulkOperation{}, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	op := BulkOperation{Operation: o.operation, Collection: col, Key: key}
+	if o.operation != "delete" {
+		data, err := messagePayload(batch[i])
+		if err != nil {
+			return BulkOperation{}, err
+		}
+
+		// -- drivers may alter the document, so leave the message untouched
+		op.Value = copyDocument(data)
+	}
+
+	return op, nil
+}
+
+func (o *storeOutput) Close(ctx context.Context) error {
+	return o.driver.Close()
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestOutput(t *testing.T, yaml string) *storeOutput {
+	t.Helper()
+
+	conf, err := storeOutputConfig().ParseYAML(strings.TrimSpace(yaml), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	out, _, _, err := outputFromConfig(conf, service.MockResources())
+	require.NoError(t, err)
+
+	return out
+}
+
+func TestOutput(t *testing.T) {
+	t.Run("should write batches of documents", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		out := newTestOutput(t, `
+driver:
+  memory:
+    name: output_write
+collection: concepts
+key: ${! json("id") }
+`)
+		require.NoError(t, out.Connect(tCtx))
+
+		require.NoError(t, out.WriteBatch(tCtx, service.MessageBatch{
+			newTestMessage(nil, map[string]any{"id": "a", "name": "alpha"}),
+			newTestMessage(nil, map[string]any{"id": "b", "name": "beta"}),
+		}))
+
+		doc, err := NewMemoryClient("output_write").Get(tCtx, "concepts", "b")
+		require.NoError(t, err)
+		assert.Equal(t, "beta", doc["name"])
+	})
+
+	t.Run("should only fail the messages which could not be written", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		require.NoError(t, NewMemoryClient("output_add").Add(tCtx, "concepts", "a", map[string]any{"name": "alpha"}))
+
+		out := newTestOutput(t, `
+driver:
+  memory:
+    name: output_add
+collection: concepts
+operation: add
+key: ${! json("id") }
+`)
+
+		err := out.WriteBatch(tCtx, service.MessageBatch{
+			newTestMessage(nil, map[string]any{"id": "a", "name": "alpha"}),
+			newTestMessage(nil, map[string]any{"id": "b", "name": "beta"}),
+		})
+
+		var batchErr *service.BatchError
+		require.True(t, errors.As(err, &batchErr))
+
+		var failed []int
+		batchErr.WalkMessages(func(i int, _ *service.Message, err error) bool {
+			if err != nil {
+				failed = append(failed, i)
+			}
+			return true
+		})
+		assert.Equal(t, []int{0}, failed)
+	})
+
+	t.Run("should ignore deleting missing documents", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		out := newTestOutput(t, `
+driver:
+  memory:
+    name: output_delete
+collection: concepts
+operation: delete
+key: ${! json("id") }
+`)
+
+		assert.NoError(t, out.WriteBatch(tCtx, service.MessageBatch{
+			newTestMessage(nil, map[string]any{"id": "missing"}),
+		}))
+	})
+	t.Run("should disconnect when the store can not be reached", func(t *testing.T) {
+		tCtx, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+
+		out := newTestOutput(t, `
+driver:
+  memory:
+    name: output_down
+collection: concepts
+key: ${! json("id") }
+`)
+		out.driver = &unreachableClient{MemoryClient: NewMemoryClient("output_down")}
+
+		assert.Error(t, out.Connect(tCtx))
+
+		err := out.WriteBatch(tCtx, service.MessageBatch{
+			newTestMessage(nil, map[string]any{"id": "a", "name": "alpha"}),
+		})
+		assert.ErrorIs(t, err, service.ErrNotConnected)
+	})
+}
+
+// unreachableClient is a client of a store which can not be reached.
+type unreachableClient struct {
+	*MemoryClient
+}
+
+func (c *unreachableClient) Ping(ctx context.Context) error {
+	return fmt.Errorf("connection refused")
+}
+
+func (c *unreachableClient) Bulk(ctx context.Context, ops []BulkOperation) ([]error, error) {
+	return nil, fmt.Errorf("connection refused")
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_PostgresClient__shouldBuildQuery(t *testing.T) {
+	cl := &PostgresClient{keyCol: `"key"`, docCol: `"document"`}
+
+	flt, err := parseFilter(`status == "open" AND total > 10`)
+	require.NoError(t, err)
+
+	qry, args := cl.buildQuery("orders", flt, &PagingOpts{Offset: 20, Size: 10})
+
+	assert.Equal(t, `SELECT "document" FROM "orders"`+
+		` WHERE ("document" #> $1::text[]) = $2::jsonb`+
+		` AND (jsonb_typeof(("document" #> $3::text[])) = jsonb_typeof($4::jsonb) AND ("document" #> $3::text[]) > $4::jsonb)`+
+		` ORDER BY "key" LIMIT $5 OFFSET $6`, qry)
+	assert.Equal(t, []any{
+		pq.Array([]string{"status"}), `"open"`,
+		pq.Array([]string{"total"}), `10`,
+		int64(10), int64(20),
+	}, args)
+}
+
+func Test_PostgresClient__shouldBuildNestedConditions(t *testing.T) {
+	cl := &PostgresClient{keyCol: `"key"`, docCol: `"document"`}
+
+	flt, err := ParseFilterDocument(map[string]any{
+		"status": map[string]any{"$in": []any{"open", "pending"}},
+		"$or": []any{
+			map[string]any{"owner": map[string]any{"$exists": false}},
+			map[string]any{"$not": map[string]any{"priority": "low"}},
+		},
+	})
+	require.NoError(t, err)
+
+	qry, args := cl.buildQuery("orders", flt, nil)
+
+	assert.Equal(t, `SELECT "document" FROM "orders"`+
+		` WHERE (NOT (("document" #> $1::text[]) IS NOT NULL AND ("document" #> $1::text[]) <> 'null'::jsonb)`+
+		` OR NOT (("document" #> $2::text[]) = $3::jsonb))`+
+		` AND ("document" #> $4::text[]) IN ($5::jsonb, $6::jsonb)`+
+		` ORDER BY "key"`, qry)
+	assert.Equal(t, []any{
+		pq.Array([]string{"owner"}),
+		pq.Array([]string{"priority"}), `"low"`,
+		pq.Array([]string{"status"}), `"open"`, `"pending"`,
+	}, args)
+}
+
+func Test_PostgresClient__shouldBuildAggregateQuery(t *testing.T) {
+	cl := &PostgresClient{keyCol: `"key"`, docCol: `"document"`}
+
+	flt, err := parseFilter(`status == "open"`)
+	require.NoError(t, err)
+
+	qry, args := cl.buildAggregateQuery("orders", flt, Aggregation{
+		GroupBy: [][]string{{"customer", "id"}},
+		Metrics: []Metric{
+			{Name: "orders", Func: MetricCount},
+			{Name: "revenue", Func: MetricSum, Path: []string{"total"}},
+		},
+	})
+
+	assert.Equal(t, `SELECT COUNT(*), ("document" #> $1::text[]), NULL,`+
+		` SUM(CASE WHEN jsonb_typeof(("document" #> $2::text[])) = 'number' THEN ("document" #> $2::text[])::numeric END)`+
+		` FROM "orders" WHERE ("document" #> $3::text[]) = $4::jsonb`+
+		` GROUP BY 2 ORDER BY 2 NULLS FIRST`, qry)
+	assert.Equal(t, []any{
+		pq.Array([]string{"customer", "id"}),
+		pq.Array([]string{"total"}),
+		pq.Array([]string{"status"}), `"open"`,
+	}, args)
+}
+
+func Test_PostgresClient__shouldBuildCollectionStatements(t *testing.T) {
+	cl := &PostgresClient{keyCol: `"key"`, docCol: `"document"`}
+
+	stmts := cl.collectionStatements("orders", CollectionSpec{Indexes: []IndexSpec{
+		{Fields: []string{"customer.id", "status"}},
+		{Name: "orders_number", Fields: []string{"number"}, Unique: true, Sparse: true},
+	}})
+
+	assert.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "orders" ("key" text PRIMARY KEY, "document" jsonb NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS "orders_customer_id_status_idx" ON "orders" (("document" #> '{"customer","id"}'), ("document" #> '{"status"}'))`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS "orders_number" ON "orders" (("document" #> '{"number"}')) WHERE ("document" #> '{"number"}') IS NOT NULL`,
+	}, stmts)
+}
+
+func Test_PostgresCursor__shouldSurfaceRowErrors(t *testing.T) {
+	db := sql.OpenDB(failingRowsConnector{})
+	defer db.Close()
+
+	rows, err := db.Query("SELECT doc FROM concepts")
+	require.NoError(t, err)
+
+	cur := &postgresCursor{rows: rows}
+	defer cur.Close()
+
+	require.True(t, cur.HasNext())
+	doc, err := cur.Read()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "alpha"}, doc)
+
+	// -- the rows ending on an error are not mistaken for the end of the results
+	require.True(t, cur.HasNext())
+	_, err = cur.Read()
+	assert.ErrorContains(t, err, "connection reset")
+}
+
+// failingRowsConnector connects to a database of which every query returns a single document before failing.
+type failingRowsConnector struct{}
+
+func (c failingRowsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return failingRowsConn{}, nil
+}
+func (c failingRowsConnector) Driver() driver.Driver { return nil }
+
+type failingRowsConn struct{}
+
+func (c failingRowsConn) Prepare(query string) (driver.Stmt, error) { return failingRowsStmt{}, nil }
+func (c failingRowsConn) Close() error                              { return nil }
+func (c failingRowsConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("not supported") }
+
+type failingRowsStmt struct{}
+
+func (s failingRowsStmt) Close() error  { return nil }
+func (s failingRowsStmt) NumInput() int { return -1 }
+func (s failingRowsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (s failingRowsStmt) Query(args []driver.Value) (driver.Rows, error) { return &failingRows{}, nil }
+
+type failingRows struct {
+	read bool
+}
+
+func (r *failingRows) Columns() []string { return []string{"doc"} }
+func (r *failingRows) Close() error      { return nil }
+
+func (r *failingRows) Next(dest []driver.Value) error {
+	if r.read {
+		return fmt.Errorf("connection reset")
+	}
+
+	r.read = true
+	dest[0] = []byte(`{"name": "alpha"}`)
+	return nil
+}
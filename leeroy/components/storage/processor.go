@@ -3,16 +3,20 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/benthosdev/benthos/v4/public/bloblang"
 	"github.com/benthosdev/benthos/v4/public/service"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 func init() {
-	err := service.RegisterProcessor("storage", storeProcConfig(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.Processor, error) {
-		return procFromConfig(conf, mgr)
-	})
-	if err != nil {
+	if err := registerComponents(); err != nil {
 		panic(err)
 	}
 }
@@ -23,22 +27,124 @@ func storeProcConfig() *service.ConfigSpec {
 		Categories("Integration")
 
 	return spec.
-		Field(service.NewObjectField("driver",
-			service.NewObjectField("arangodb", ArangodbConfigFields()...).Default(nil),
-			service.NewObjectField("elasticsearch", ElasticsearchConfigFields()...).Default(nil),
-		)).
+		Field(DriverField()).
 		Field(service.NewInterpolatedStringField("collection").
 			Description("The reference to the concept to manipulate the store for")).
 		Field(service.NewStringField("operation").
-			Description("The operation to perform, one of: 'list', 'get', 'add', 'set', 'merge' or 'delete'")).
+			Description("The operation to perform, one of: 'list', 'count', 'aggregate', 'get', 'add', 'set', 'merge', 'delete', 'link', 'unlink' or 'traverse'. The graph operations 'link', 'unlink' and 'traverse' act on the edges held by the collection and are only supported by arangodb")).
 		Field(service.NewInterpolatedStringField("key").
 			Description("The key to use. This is only applicable for 'get', 'add', 'set', 'merge' and 'delete'").
 			Optional()).
+		Field(service.NewInterpolatedStringField("revision").
+			Description("The revision the document is expected to be at. When set, 'set', 'merge' and 'delete' fail if the document has been changed in the meantime. The current revision of a document is available in the `storage_revision` metadata after each operation, for drivers supporting revisions.").
+			Example(`${! meta("storage_revision") }`).
+			Optional()).
+		Field(service.NewObjectField("ensure_collection",
+			service.NewBoolField("enabled").
+				Description("Create the collection on first use if it does not exist yet.").
+				Default(false),
+			service.NewAnyMapField("mappings").
+				Description("The mappings of the index to create, for elasticsearch.").
+				Optional(),
+			service.NewAnyMapField("settings").
+				Description("The settings of the index to create, for elasticsearch.").
+				Optional(),
+			service.NewObjectListField("indexes",
+				service.NewStringField("name").
+					Description("The name of the index. A name is generated from the collection and the fields when empty.").
+					Default(""),
+				service.NewStringListField("fields").
+					Description("The dotted paths of the fields to index."),
+				service.NewBoolField("unique").
+					Description("Whether the combination of the indexed fields must be unique.").
+					Default(false),
+				service.NewBoolField("sparse").
+					Description("Whether to leave documents without the indexed fields out of the index.").
+					Default(false),
+			).
+				Description("The secondary indexes to create, for arangodb and postgres.").
+				Default([]any{}),
+		).
+			Description("Create the collection, index or table the documents are stored in before using it, instead of requiring it to be set up up front. Existing collections are left untouched apart from creating missing indexes.").
+			Advanced()).
+		Field(healthCheckField()).
+		Field(service.NewInterpolatedStringField("ttl").
+			Description("How long documents written by 'set', 'add' and 'merge' live, as a duration like `24h`. The expiry time is stored in the `ttl_field` of the document. Arangodb removes expired documents using a TTL index, other drivers have them purged every `ttl_purge_interval` by the processor. Expired documents may still be read until they have been removed. Documents are kept forever when the ttl is empty.").
+			Example("30m").
+			Optional()).
+		Field(service.NewStringField("ttl_field").
+			Description("The dotted path of the field holding the time a document expires at.").
+			Default("_expires_at").
+			Advanced()).
+		Field(service.NewStringField("ttl_purge_interval").
+			Description("How often expired documents are purged, for drivers without native support for expiring documents.").
+			Default("1m").
+			Advanced()).
+		Field(softDeleteField()).
+		Fields(graphFields()...).
+		Field(service.NewStringAnnotatedEnumField("on_missing", map[string]string{
+			"error": "Flag the message with an error which matches `service.ErrKeyNotFound`.",
+			"skip":  "Pass the original message through, only adding the storage metadata.",
+			"empty": "Emit an empty document holding the metadata of the original message.",
+		}).
+			Description("What to do when the document to 'get' or 'delete' does not exist.").
+			Default("error")).
+		Field(service.NewBoolField("bulk").
+			Description("Apply the 'set', 'add' and 'delete' operations of all messages within a batch in as few requests to the store as possible. Messages for which the operation failed are flagged with the error, the others are passed through unchanged.").
+			Default(false)).
 		Field(service.NewBoolField("enable_pit").
 			Description("Enable point in time queries").
 			Default(false)).
 		Field(service.NewInterpolatedStringField("q").
-			Description("The query to pass to the driver. This is only applicable for 'list'").
+			Description("The native query to pass to the driver. Prefer `filter` unless the query can not be expressed as a filter. This is only applicable for 'list', 'count' and 'aggregate'").
+			Optional()).
+		Field(service.NewBloblangField("filter").
+			Description("A mapping resulting in a driver neutral filter document, selecting the documents to list. Each key of the document is a dotted field path holding either the value to match or an object of operators (`$eq`, `$ne`, `$lt`, `$lte`, `$gt`, `$gte`, `$in` and `$exists`), or one of the logical operators `$and`, `$or` and `$not`. Use `sort` to order the results. This is only applicable for 'list', 'count' and 'aggregate' and can not be combined with `q`").
+			Example(`root.status = "open"
+root.total = {"$gte": this.min_total}
+root."$or" = [{"priority": "high"}, {"owner": {"$exists": false}}]`).
+			Optional()).
+		Field(service.NewInterpolatedStringField("offset").
+			Description("The number of documents to skip. This is only applicable for 'list'").
+			Optional()).
+		Field(service.NewInterpolatedStringField("limit").
+			Description("The maximum number of documents to return. This is only applicable for 'list'").
+			Optional()).
+		Field(service.NewInterpolatedStringField("page_size").
+			Description("The number of documents to fetch from the store per round trip. This is only applicable for 'list'").
+			Optional()).
+		Field(service.NewInterpolatedStringField("sort").
+			Description("A comma separated list of fields to order the documents by, each optionally followed by `:asc` or `:desc`. This is only applicable for 'list'").
+			Example("created_at:desc,name").
+			Optional()).
+		Field(service.NewInterpolatedStringField("pit_keep_alive").
+			Description("How long a point in time is kept alive between fetching two pages. This is only applicable for 'list' with `enable_pit` set").
+			Default("1m")).
+		Field(service.NewStringAnnotatedEnumField("list_output", map[string]string{
+			"single":    "Emit all listed documents as an array within a single message.",
+			"documents": "Emit a batch holding a message per listed document.",
+			"pages":     "Emit a batch holding a message per page of listed documents, each page being an array of at most `list_page_size` documents.",
+		}).
+			Description("How the results of a 'list' operation are emitted. All listed documents are read into memory before the results are emitted, whichever the output, so use the `storage_list` input to walk large collections instead.").
+			Default("single")).
+		Field(service.NewIntField("list_page_size").
+			Description("The maximum number of documents within a page when `list_output` is set to 'pages'").
+			Default(100)).
+		Field(service.NewStringListField("group_by").
+			Description("The dotted paths of the fields to group the documents by. Each group is emitted as an object holding the group fields and the metrics. This is only applicable for 'aggregate'").
+			Example([]string{"customer.id", "status"}).
+			Optional()).
+		Field(service.NewStringMapField("metrics").
+			Description("The metrics to calculate for each group, by name. A metric is one of `count()`, `sum(field)`, `avg(field)`, `min(field)` or `max(field)`, only numeric values being taken into account by the latter. When omitted, the documents are counted. This is only applicable for 'aggregate'").
+			Example(map[string]any{"orders": "count()", "revenue": "sum(total)"}).
+			Optional()).
+		Field(service.NewBloblangField("result_map").
+			Description("A mapping, like the `result_map` of a `branch` processor, placing the result of the operation into the original message instead of replacing it. Within the mapping `this` refers to the result and `root` to the original document. Metadata can not be set from the mapping. Each result message carries the `storage_operation` and `storage_collection` metadata, along with `storage_key`, `storage_found` and `storage_revision` when applicable. This can not be combined with `bulk`").
+			Example(`root.customer = this`).
+			Optional()).
+		Field(service.NewBloblangField("args_mapping").
+			Description("An optional mapping resulting in an object of named parameters to bind to the query. Use this instead of interpolating message content into the query for drivers supporting parameterised queries, like arangodb. This is only applicable for 'list' and can not be combined with `enable_pit`").
+			Example(`root.status = this.status`).
 			Optional())
 }
 
@@ -51,21 +157,18 @@ func procFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (proc *s
 	}
 	proc.collection = collection
 
-	if IsArangodbConfigured(conf.Namespace("driver")) {
-		driver, err := NewArangodbClientFromConfig(conf.Namespace("driver", "arangodb"), mgr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create arangodb driver: %w", err)
-		}
-		proc.driver = driver
-	} else if IsElasticsearchConfigured(conf.Namespace("driver")) {
-		driver, err := NewElasticsearchClientFromConfig(conf.Namespace("driver", "elasticsearch"), mgr)
+	driver, err := NewClientFromConfig(conf.Namespace("driver"), mgr)
+	if err != nil {
+		return nil, err
+	}
+	proc.driver = driver
+
+	// -- release the driver when the remaining configuration turns out to be invalid
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("failed to create elasticsearch driver: %w", err)
+			_ = driver.Close()
 		}
-		proc.driver = driver
-	} else {
-		return nil, fmt.Errorf("no driver specified")
-	}
+	}()
 
 	proc.pit, err = conf.FieldBool("enable_pit")
 	if err != nil {
@@ -77,6 +180,46 @@ func procFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (proc *s
 		return nil, fmt.Errorf("failed to get operation: %w", err)
 	}
 
+	if proc.ensure, err = conf.FieldBool("ensure_collection", "enabled"); err != nil {
+		return nil, fmt.Errorf("failed to get ensure_collection flag: %w", err)
+	}
+
+	if proc.ensure {
+		if proc.collectionSpec, err = collectionSpecFromConfig(conf.Namespace("ensure_collection")); err != nil {
+			return nil, err
+		}
+		proc.ensured = map[string]*ensuredCollection{}
+	}
+
+	proc.onMissing, err = conf.FieldString("on_missing")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get on_missing: %w", err)
+	}
+
+	proc.bulk, err = conf.FieldBool("bulk")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bulk flag: %w", err)
+	}
+
+	if proc.bulk && !IsBulkOperation(proc.operation) {
+		return nil, fmt.Errorf("operation %q can not be applied in bulk", proc.operation)
+	}
+
+	if conf.Contains("revision") {
+		if _, ok := proc.driver.(RevisionClient); !ok {
+			return nil, fmt.Errorf("the driver does not support revisions")
+		}
+
+		if proc.bulk {
+			return nil, fmt.Errorf("revisions can not be checked in bulk")
+		}
+
+		proc.revision, err = conf.FieldInterpolatedString("revision")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get revision: %w", err)
+		}
+	}
+
 	if conf.Contains("key") {
 		proc.key, err = conf.FieldInterpolatedString("key")
 		if err != nil {
@@ -91,6 +234,151 @@ func procFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (proc *s
 		}
 	}
 
+	if conf.Contains("filter") {
+		if proc.q != nil {
+			return nil, fmt.Errorf("only one of q and filter can be specified")
+		}
+
+		proc.filter, err = conf.FieldBloblang("filter")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get filter: %w", err)
+		}
+	}
+
+	if conf.Contains("offset") {
+		proc.offset, err = conf.FieldInterpolatedString("offset")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offset: %w", err)
+		}
+	}
+
+	if conf.Contains("limit") {
+		proc.limit, err = conf.FieldInterpolatedString("limit")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get limit: %w", err)
+		}
+	}
+
+	if conf.Contains("page_size") {
+		proc.pageSize, err = conf.FieldInterpolatedString("page_size")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get page size: %w", err)
+		}
+	}
+
+	if conf.Contains("sort") {
+		proc.sort, err = conf.FieldInterpolatedString("sort")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sort: %w", err)
+		}
+	}
+
+	proc.pitKeepAlive, err = conf.FieldInterpolatedString("pit_keep_alive")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pit keep alive: %w", err)
+	}
+
+	proc.listOutput, err = conf.FieldString("list_output")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get list output: %w", err)
+	}
+
+	proc.listPageSize, err = conf.FieldInt("list_page_size")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get list page size: %w", err)
+	}
+	if proc.listPageSize <= 0 {
+		return nil, fmt.Errorf("list page size must be larger than 0")
+	}
+
+	if conf.Contains("group_by") {
+		groupBy, err := conf.FieldStringList("group_by")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group by: %w", err)
+		}
+
+		for _, field := range groupBy {
+			proc.aggregation.GroupBy = append(proc.aggregation.GroupBy, strings.Split(field, "."))
+		}
+	}
+
+	metrics := map[string]string{"count": "count()"}
+	if conf.Contains("metrics") {
+		configured, err := conf.FieldStringMap("metrics")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metrics: %w", err)
+		}
+
+		if len(configured) > 0 {
+			metrics = configured
+		}
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m, err := ParseMetric(name, metrics[name])
+		if err != nil {
+			return nil, err
+		}
+		proc.aggregation.Metrics = append(proc.aggregation.Metrics, m)
+	}
+
+	if IsGraphOperation(proc.operation) {
+		if err := proc.graphFromConfig(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Contains("ttl") {
+		if proc.ttl, proc.expirer, err = expiryFromConfig(conf, proc.driver, proc.operation, mgr); err != nil {
+			return nil, err
+		}
+	}
+
+	if proc.tombstones, err = tombstonesFromConfig(conf.Namespace("soft_delete")); err != nil {
+		return nil, err
+	}
+
+	if proc.tombstones != nil && proc.bulk && proc.operation == "delete" {
+		return nil, fmt.Errorf("documents can not be soft deleted in bulk")
+	}
+
+	if proc.excludesTombstones() && proc.filter == nil && proc.q != nil && (proc.operation == "count" || proc.operation == "aggregate") {
+		return nil, fmt.Errorf("a filter is required to leave deleted documents out of %s", proc.operation)
+	}
+
+	if conf.Contains("result_map") {
+		if proc.bulk {
+			return nil, fmt.Errorf("a result map can not be applied in bulk")
+		}
+
+		proc.resultMap, err = conf.FieldBloblang("result_map")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get result map: %w", err)
+		}
+	}
+
+	if conf.Contains("args_mapping") {
+		if proc.pit {
+			return nil, fmt.Errorf("args_mapping can not be combined with enable_pit")
+		}
+
+		proc.argsMapping, err = conf.FieldBloblang("args_mapping")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get args mapping: %w", err)
+		}
+	}
+
+	// -- only wait for the store once the configuration is known to be valid
+	if proc.health, err = healthFromConfig(conf.Namespace("health_check"), proc.driver, mgr); err != nil {
+		return nil, err
+	}
+
 	return proc, nil
 }
 
@@ -98,13 +386,97 @@ type storeProc struct {
 	driver     Client
 	collection *service.InterpolatedString
 
-	operation string
-	key       *service.InterpolatedString
-	q         *service.InterpolatedString
-	pit       bool
+	ensure         bool
+	collectionSpec CollectionSpec
+	ensuredMu      sync.Mutex
+	ensured        map[string]*ensuredCollection
+
+	operation   string
+	onMissing   string
+	tombstones  *tombstones
+	health      *healthMonitor
+	ttl         *service.InterpolatedString
+	expirer     *expirer
+	resultMap   *bloblang.Executor
+	bulk        bool
+	key         *service.InterpolatedString
+	revision    *service.InterpolatedString
+	q           *service.InterpolatedString
+	filter      *bloblang.Executor
+	argsMapping *bloblang.Executor
+	pit         bool
+
+	offset       *service.InterpolatedString
+	limit        *service.InterpolatedString
+	pageSize     *service.InterpolatedString
+	sort         *service.InterpolatedString
+	pitKeepAlive *service.InterpolatedString
+
+	listOutput   string
+	listPageSize int
+
+	aggregation Aggregation
+
+	from      *vertexExpr
+	to        *vertexExpr
+	traversal TraversalOpts
+}
+
+func (s *storeProc) ProcessBatch(ctx context.Context, batch service.MessageBatch) ([]service.MessageBatch, error) {
+	if s.bulk {
+		return []service.MessageBatch{s.processBulk(ctx, batch)}, nil
+	}
+
+	var result service.MessageBatch
+	for _, message := range batch {
+		res, err := s.Process(ctx, message)
+		if err != nil {
+			message.SetError(err)
+			result = append(result, message)
+			continue
+		}
+
+		result = append(result, res...)
+	}
+
+	return []service.MessageBatch{result}, nil
 }
 
 func (s *storeProc) Process(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	if s.ensure {
+		if err := s.ensureCollection(ctx, col); err != nil {
+			return nil, err
+		}
+	}
+
+	batch, err := s.process(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range batch {
+		result.MetaSetMut("storage_operation", s.operation)
+		result.MetaSetMut("storage_collection", col)
+
+		// -- skipped messages hold no result to map
+		if s.resultMap == nil || result == message {
+			continue
+		}
+
+		if batch[i], err = s.applyResultMap(message, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return batch, nil
+}
+
+func (s *storeProc) process(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
 	switch s.operation {
 	case "get":
 		return s.processGet(ctx, message)
@@ -118,15 +490,143 @@ func (s *storeProc) Process(ctx context.Context, message *service.Message) (serv
 		return s.processDelete(ctx, message)
 	case "list":
 		return s.processList(ctx, message)
+	case "count":
+		return s.processCount(ctx, message)
+	case "aggregate":
+		return s.processAggregate(ctx, message)
+	case "link":
+		return s.processLink(ctx, message)
+	case "unlink":
+		return s.processUnlink(ctx, message)
+	case "traverse":
+		return s.processTraverse(ctx, message)
 	default:
 		return nil, fmt.Errorf("unknown operation: %s", s.operation)
 	}
 }
 
+// ensureCollection ensures the collection exists the first time it is used by the processor. Only messages for the
+// same collection wait for the collection to be created.
+func (s *storeProc) ensureCollection(ctx context.Context, col string) error {
+	s.ensuredMu.Lock()
+	ec, fnd := s.ensured[col]
+	if !fnd {
+		ec = &ensuredCollection{}
+		s.ensured[col] = ec
+	}
+	s.ensuredMu.Unlock()
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.done {
+		return nil
+	}
+
+	// -- a failed attempt is retried with the next message
+	if err := EnsureCollection(ctx, s.driver, col, s.collectionSpec); err != nil {
+		return err
+	}
+
+	ec.done = true
+	return nil
+}
+
+// ensuredCollection tracks whether a collection has been ensured, serializing the attempts to ensure it.
+type ensuredCollection struct {
+	mu   sync.Mutex
+	done bool
+}
+
 func (s *storeProc) Close(ctx context.Context) error {
+	if s.expirer != nil {
+		s.expirer.Close()
+	}
+
+	if s.health != nil {
+		s.health.Close()
+	}
+
 	return s.driver.Close()
 }
 
+// processBulk applies the operation for all messages of the batch at once, flagging the messages for which it failed.
+func (s *storeProc) processBulk(ctx context.Context, batch service.MessageBatch) service.MessageBatch {
+	var ops []BulkOperation
+	var opMessages []*service.Message
+
+	for _, message := range batch {
+		op, err := s.bulkOperation(ctx, message)
+		if err != nil {
+			message.SetError(err)
+			continue
+		}
+
+		message.MetaSetMut("storage_operation", s.operation)
+		message.MetaSetMut("storage_collection", op.Collection)
+		message.MetaSetMut("storage_key", op.Key)
+
+		ops = append(ops, op)
+		opMessages = append(opMessages, message)
+	}
+
+	if len(ops) == 0 {
+		return batch
+	}
+
+	errs, err := Bulk(ctx, s.driver, ops)
+	if err != nil {
+		for _, message := range opMessages {
+			message.SetError(fmt.Errorf("unable to apply bulk %s: %w", s.operation, err))
+		}
+		return batch
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			opMessages[i].SetError(fmt.Errorf("unable to %s document with key %s: %w", s.operation, ops[i].Key, err))
+		}
+	}
+
+	return batch
+}
+
+func (s *storeProc) bulkOperation(ctx context.Context, message *service.Message) (BulkOperation, error) {
+	key, err := s.key.TryString(message)
+	if err != nil {
+		return BulkOperation{}, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return BulkOperation{}, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	if s.ensure {
+		if err := s.ensureCollection(ctx, col); err != nil {
+			return BulkOperation{}, err
+		}
+	}
+
+	op := BulkOperation{Operation: s.operation, Collection: col, Key: key}
+
+	if s.operation != "delete" {
+		data, err := messagePayload(message)
+		if err != nil {
+			return BulkOperation{}, err
+		}
+
+		// -- drivers may alter the document, so leave the message untouched
+		op.Value = copyDocument(data)
+
+		if err := s.expire(ctx, message, col, op.Value); err != nil {
+			return BulkOperation{}, err
+		}
+	}
+
+	return op, nil
+}
+
 func (s *storeProc) processGet(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
 	// -- get the key from the message
 	key, err := s.key.TryString(message)
@@ -139,18 +639,30 @@ func (s *storeProc) processGet(ctx context.Context, message *service.Message) (s
 		return nil, fmt.Errorf("invalid collection: %w", err)
 	}
 
-	res, err := s.driver.Get(ctx, col, key)
+	res, rev, err := s.get(ctx, col, key)
+	if errors.Is(err, ErrNotFound) {
+		return s.missing(message, key)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to read document with key %q: %w", key, err)
 	}
 
+	deleted := s.tombstones != nil && s.tombstones.isTombstone(res)
+	if deleted && !s.tombstones.includeDeleted {
+		return s.missing(message, key)
+	}
+
 	result := service.NewMessage(nil)
 	result.SetStructured(res)
 
 	CopyMeta(message, result)
+	setDocumentMeta(result, key, true)
+	setRevisionMeta(result, rev)
+	if deleted {
+		result.MetaSetMut("storage_deleted", true)
+	}
 
 	return service.MessageBatch{result}, nil
-
 }
 
 func (s *storeProc) processAdd(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
@@ -160,7 +672,7 @@ func (s *storeProc) processAdd(ctx context.Context, message *service.Message) (s
 		return nil, fmt.Errorf("failed to get key: %w", err)
 	}
 
-	data, err := s.getMessagePayload(message)
+	data, err := messagePayload(message)
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +687,10 @@ func (s *storeProc) processAdd(ctx context.Context, message *service.Message) (s
 		return nil, fmt.Errorf("invalid collection: %w", err)
 	}
 
+	if err := s.expire(ctx, message, col, data); err != nil {
+		return nil, err
+	}
+
 	if err := s.driver.Add(ctx, col, key, data); err != nil {
 		return nil, fmt.Errorf("unable to add document with key %s: %w", key, err)
 	}
@@ -183,6 +699,7 @@ func (s *storeProc) processAdd(ctx context.Context, message *service.Message) (s
 	result.SetStructured(data)
 
 	CopyMeta(message, result)
+	result.MetaSetMut("storage_key", key)
 
 	return service.MessageBatch{result}, nil
 }
@@ -194,7 +711,7 @@ func (s *storeProc) processReplace(ctx context.Context, message *service.Message
 		return nil, fmt.Errorf("failed to get key: %w", err)
 	}
 
-	data, err := s.getMessagePayload(message)
+	data, err := messagePayload(message)
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +726,17 @@ func (s *storeProc) processReplace(ctx context.Context, message *service.Message
 		return nil, fmt.Errorf("invalid collection: %w", err)
 	}
 
-	if err := s.driver.Set(ctx, col, key, data); err != nil {
+	expected, err := s.expectedRevision(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.expire(ctx, message, col, data); err != nil {
+		return nil, err
+	}
+
+	rev, err := s.set(ctx, col, key, data, expected)
+	if err != nil {
 		return nil, fmt.Errorf("unable to set document with key %s: %w", key, err)
 	}
 
@@ -217,6 +744,8 @@ func (s *storeProc) processReplace(ctx context.Context, message *service.Message
 	result.SetStructured(data)
 
 	CopyMeta(message, result)
+	result.MetaSetMut("storage_key", key)
+	setRevisionMeta(result, rev)
 
 	return service.MessageBatch{result}, nil
 }
@@ -228,7 +757,7 @@ func (s *storeProc) processMerge(ctx context.Context, message *service.Message)
 		return nil, fmt.Errorf("failed to get key: %w", err)
 	}
 
-	data, err := s.getMessagePayload(message)
+	data, err := messagePayload(message)
 	if err != nil {
 		return nil, err
 	}
@@ -243,7 +772,16 @@ func (s *storeProc) processMerge(ctx context.Context, message *service.Message)
 		return nil, fmt.Errorf("invalid collection: %w", err)
 	}
 
-	merged, err := s.driver.Merge(ctx, col, key, data)
+	expected, err := s.expectedRevision(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.expire(ctx, message, col, data); err != nil {
+		return nil, err
+	}
+
+	merged, rev, err := s.merge(ctx, col, key, data, expected)
 	if err != nil {
 		return nil, fmt.Errorf("unable to set document with key %s: %w", key, err)
 	}
@@ -252,6 +790,8 @@ func (s *storeProc) processMerge(ctx context.Context, message *service.Message)
 	result.SetStructured(merged)
 
 	CopyMeta(message, result)
+	result.MetaSetMut("storage_key", key)
+	setRevisionMeta(result, rev)
 
 	return service.MessageBatch{result}, nil
 }
@@ -272,13 +812,30 @@ func (s *storeProc) processDelete(ctx context.Context, message *service.Message)
 		return nil, fmt.Errorf("invalid collection: %w", err)
 	}
 
+	if s.tombstones != nil {
+		return s.softDelete(ctx, message, col, key)
+	}
+
 	// -- get the document so we can return it
 	data, err := s.driver.Get(ctx, col, key)
+	if errors.Is(err, ErrNotFound) {
+		return s.missing(message, key)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to read document with key %q: %w", key, err)
 	}
 
-	if err := s.driver.Delete(ctx, col, key); err != nil {
+	expected, err := s.expectedRevision(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// -- the document may have been removed since it was read
+	err = s.delete(ctx, col, key, expected)
+	if errors.Is(err, ErrNotFound) && expected == "" {
+		return s.missing(message, key)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("unable to delete document with key %s: %w", key, err)
 	}
 
@@ -286,41 +843,291 @@ func (s *storeProc) processDelete(ctx context.Context, message *service.Message)
 	result.SetStructured(data)
 
 	CopyMeta(message, result)
+	setDocumentMeta(result, key, true)
 
 	return service.MessageBatch{result}, nil
 }
 
-func (s *storeProc) processList(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
-	q, err := s.q.TryString(message)
+// expire sets the time the document expires at when the message has a ttl, making sure the document gets removed from
+// the collection once expired.
+func (s *storeProc) expire(ctx context.Context, message *service.Message, col string, data map[string]any) error {
+	if s.ttl == nil {
+		return nil
+	}
+
+	str, err := s.ttl.TryString(message)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse query: %w", err)
+		return fmt.Errorf("failed to get ttl: %w", err)
 	}
 
-	var qry any
-	if s.pit {
-		qry, err = s.driver.ParseQuery(q)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse query: %w", err)
-		}
-	} else {
-		qry = q
+	if str == "" {
+		return nil
 	}
 
-	col, err := s.collection.TryString(message)
+	ttl, err := time.ParseDuration(str)
 	if err != nil {
-		return nil, fmt.Errorf("invalid collection: %w", err)
+		return fmt.Errorf("invalid ttl %q: %w", str, err)
 	}
 
-	cur, err := s.driver.List(ctx, col, qry, s.pit, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list documents: %w", err)
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %s", str)
 	}
-	defer func() {
-		if err := cur.Close(); err != nil {
-			logrus.Warn("error closing cursor: %w", err)
-		}
-	}()
 
+	setPath(data, s.expirer.path, s.expirer.expiresAt(time.Now(), ttl))
+
+	return s.expirer.track(ctx, col)
+}
+
+// softDelete marks the document as deleted, leaving it in place as a tombstone.
+func (s *storeProc) softDelete(ctx context.Context, message *service.Message, col string, key string) (service.MessageBatch, error) {
+	data, rev, err := s.get(ctx, col, key)
+	if errors.Is(err, ErrNotFound) {
+		return s.missing(message, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read document with key %q: %w", key, err)
+	}
+
+	if s.tombstones.isTombstone(data) {
+		return s.missing(message, key)
+	}
+
+	expected, err := s.expectedRevision(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// -- make sure the document did not change since it was read, if the driver supports revisions
+	if expected == "" {
+		expected = rev
+	}
+
+	tombstone, err := s.tombstones.tombstone(message, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	merged, rev, err := s.merge(ctx, col, key, tombstone, expected)
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete document with key %s: %w", key, err)
+	}
+
+	result := service.NewMessage(nil)
+	result.SetStructured(merged)
+
+	CopyMeta(message, result)
+	setDocumentMeta(result, key, true)
+	setRevisionMeta(result, rev)
+	result.MetaSetMut("storage_deleted", true)
+
+	return service.MessageBatch{result}, nil
+}
+
+func (s *storeProc) processList(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	qry, err := s.listQuery(message)
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	paging, err := s.pagingOpts(message)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := s.driver.List(ctx, col, qry, s.pit, paging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	// -- native queries can not be extended to leave out tombstones
+	if _, ok := qry.(*Filter); !ok && s.excludesTombstones() {
+		cur = &tombstoneCursor{Cursor: cur, tombstones: s.tombstones}
+	}
+	defer func() {
+		if err := cur.Close(); err != nil {
+			logrus.Warnf("error closing cursor: %v", err)
+		}
+	}()
+
+	switch s.listOutput {
+	case "documents":
+		return s.listDocuments(cur, col, message)
+	case "pages":
+		return s.listPages(cur, col, message)
+	default:
+		return s.listSingle(cur, message)
+	}
+}
+
+func (s *storeProc) processLink(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	from, to, err := s.edgeVertices(message)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := messagePayload(message)
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	edge, err := s.driver.(GraphClient).Link(ctx, col, from, to, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to link %s to %s: %w", from.ID(), to.ID(), err)
+	}
+
+	result := service.NewMessage(nil)
+	result.SetStructured(edge)
+
+	CopyMeta(message, result)
+	setEdgeMeta(result, from, to)
+	if key, ok := edge["_key"].(string); ok {
+		result.MetaSetMut("storage_key", key)
+	}
+
+	return service.MessageBatch{result}, nil
+}
+
+// processUnlink removes the edges between the vertices, passing the original message through.
+func (s *storeProc) processUnlink(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	from, to, err := s.edgeVertices(message)
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	setEdgeMeta(message, from, to)
+
+	err = s.driver.(GraphClient).Unlink(ctx, col, from, to)
+	if errors.Is(err, ErrNotFound) {
+		return s.missing(message, from.ID()+" -> "+to.ID())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to unlink %s from %s: %w", from.ID(), to.ID(), err)
+	}
+
+	message.MetaSetMut("storage_found", true)
+
+	return service.MessageBatch{message}, nil
+}
+
+func (s *storeProc) processTraverse(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	start, err := s.from.vertex(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from vertex: %w", err)
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	cur, err := s.driver.(GraphClient).Traverse(ctx, col, start, s.traversal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to traverse from %s: %w", start.ID(), err)
+	}
+	defer func() {
+		if err := cur.Close(); err != nil {
+			logrus.Warnf("error closing cursor: %v", err)
+		}
+	}()
+
+	switch s.listOutput {
+	case "documents":
+		return s.listDocuments(cur, col, message)
+	case "pages":
+		return s.listPages(cur, col, message)
+	default:
+		return s.listSingle(cur, message)
+	}
+}
+
+func (s *storeProc) edgeVertices(message *service.Message) (Vertex, Vertex, error) {
+	from, err := s.from.vertex(message)
+	if err != nil {
+		return Vertex{}, Vertex{}, fmt.Errorf("invalid from vertex: %w", err)
+	}
+
+	to, err := s.to.vertex(message)
+	if err != nil {
+		return Vertex{}, Vertex{}, fmt.Errorf("invalid to vertex: %w", err)
+	}
+
+	return from, to, nil
+}
+
+func setEdgeMeta(message *service.Message, from Vertex, to Vertex) {
+	message.MetaSetMut("storage_from", from.ID())
+	message.MetaSetMut("storage_to", to.ID())
+}
+
+func (s *storeProc) processCount(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	qry, err := s.listQuery(message)
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	cnt, err := Count(ctx, s.driver, col, qry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	result := service.NewMessage(nil)
+	result.SetStructured(map[string]any{"count": cnt})
+
+	CopyMeta(message, result)
+
+	return service.MessageBatch{result}, nil
+}
+
+func (s *storeProc) processAggregate(ctx context.Context, message *service.Message) (service.MessageBatch, error) {
+	qry, err := s.listQuery(message)
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := s.collection.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection: %w", err)
+	}
+
+	groups, err := Aggregate(ctx, s.driver, col, qry, s.aggregation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate documents: %w", err)
+	}
+
+	docs := make([]any, 0, len(groups))
+	for _, g := range groups {
+		docs = append(docs, g)
+	}
+
+	result := service.NewMessage(nil)
+	result.SetStructured(docs)
+
+	CopyMeta(message, result)
+
+	return service.MessageBatch{result}, nil
+}
+
+func (s *storeProc) listSingle(cur Cursor, message *service.Message) (service.MessageBatch, error) {
 	docs := []any{}
 	for cur.HasNext() {
 		doc, err := cur.Read()
@@ -339,7 +1146,334 @@ func (s *storeProc) processList(ctx context.Context, message *service.Message) (
 	return service.MessageBatch{result}, nil
 }
 
-func (s *storeProc) getMessagePayload(message *service.Message) (map[string]any, error) {
+// listDocuments emits a message per document, annotated with the collection, the position of the document within the
+// cursor and its score if the driver provided one.
+func (s *storeProc) listDocuments(cur Cursor, col string, message *service.Message) (service.MessageBatch, error) {
+	var batch service.MessageBatch
+	for position := 0; cur.HasNext(); position++ {
+		doc, err := cur.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document at position %d: %w", position, err)
+		}
+
+		result := service.NewMessage(nil)
+		result.SetStructured(doc)
+
+		CopyMeta(message, result)
+		setListMeta(result, col, position, doc)
+
+		batch = append(batch, result)
+	}
+
+	return batch, nil
+}
+
+// setListMeta annotates the message holding a listed document with the collection, the position of the document within
+// the cursor and its score if the driver provided one.
+func setListMeta(message *service.Message, col string, position int, doc map[string]any) {
+	message.MetaSetMut("storage_collection", col)
+	message.MetaSetMut("storage_position", position)
+	if score, fnd := doc["_score"]; fnd {
+		message.MetaSetMut("storage_score", score)
+	}
+}
+
+// listPages emits a message per page of documents, annotated with the collection, the index of the page and the
+// position of the first document of the page within the cursor.
+func (s *storeProc) listPages(cur Cursor, col string, message *service.Message) (service.MessageBatch, error) {
+	var batch service.MessageBatch
+
+	position := 0
+	for page := 0; cur.HasNext(); page++ {
+		first := position
+
+		docs := make([]any, 0, s.listPageSize)
+		for len(docs) < s.listPageSize && cur.HasNext() {
+			doc, err := cur.Read()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read document at position %d: %w", position, err)
+			}
+
+			docs = append(docs, doc)
+			position++
+		}
+
+		result := service.NewMessage(nil)
+		result.SetStructured(docs)
+
+		CopyMeta(message, result)
+		result.MetaSetMut("storage_collection", col)
+		result.MetaSetMut("storage_page", page)
+		result.MetaSetMut("storage_position", first)
+
+		batch = append(batch, result)
+	}
+
+	return batch, nil
+}
+
+// missing handles a document which does not exist according to the on_missing setting.
+func (s *storeProc) missing(message *service.Message, key string) (service.MessageBatch, error) {
+	switch s.onMissing {
+	case "skip":
+		setDocumentMeta(message, key, false)
+		return service.MessageBatch{message}, nil
+	case "empty":
+		result := service.NewMessage(nil)
+		result.SetStructured(map[string]any{})
+
+		CopyMeta(message, result)
+		setDocumentMeta(result, key, false)
+
+		return service.MessageBatch{result}, nil
+	default:
+		return nil, fmt.Errorf("unable to find document with key %q: %w", key, ErrNotFound)
+	}
+}
+
+// applyResultMap maps the result onto a copy of the original message, keeping the original document apart from the
+// fields assigned by the mapping. The metadata of the result is set on the copy as well.
+func (s *storeProc) applyResultMap(original *service.Message, result *service.Message) (*service.Message, error) {
+	res, err := result.AsStructured()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get result: %w", err)
+	}
+
+	var doc any
+	if b, _ := original.AsBytes(); len(b) > 0 {
+		if doc, err = original.AsStructured(); err != nil {
+			return nil, fmt.Errorf("a result map requires a structured message: %w", err)
+		}
+	}
+
+	// -- the mapping assigns onto the document, so leave the original one untouched
+	onto := copyValue(doc)
+
+	err = s.resultMap.Overlay(res, &onto)
+	switch {
+	case errors.Is(err, bloblang.ErrRootDeleted):
+		// -- deleting the root leaves the original document in place
+		onto = doc
+	case err != nil:
+		return nil, fmt.Errorf("failed to execute result map: %w", err)
+	}
+
+	mapped := original.Copy()
+	mapped.SetStructuredMut(onto)
+
+	_ = result.MetaWalkMut(func(k string, v any) error {
+		mapped.MetaSetMut(k, v)
+		return nil
+	})
+
+	return mapped, nil
+}
+
+func (s *storeProc) expectedRevision(message *service.Message) (string, error) {
+	if s.revision == nil {
+		return "", nil
+	}
+
+	rev, err := s.revision.TryString(message)
+	if err != nil {
+		return "", fmt.Errorf("failed to get revision: %w", err)
+	}
+
+	return rev, nil
+}
+
+func (s *storeProc) get(ctx context.Context, col string, key string) (map[string]any, string, error) {
+	if rc, ok := s.driver.(RevisionClient); ok {
+		return rc.GetRevision(ctx, col, key)
+	}
+
+	doc, err := s.driver.Get(ctx, col, key)
+	return doc, "", err
+}
+
+func (s *storeProc) set(ctx context.Context, col string, key string, data map[string]any, expected string) (string, error) {
+	if rc, ok := s.driver.(RevisionClient); ok {
+		return rc.SetRevision(ctx, col, key, data, expected)
+	}
+
+	return "", s.driver.Set(ctx, col, key, data)
+}
+
+func (s *storeProc) merge(ctx context.Context, col string, key string, data map[string]any, expected string) (map[string]any, string, error) {
+	if rc, ok := s.driver.(RevisionClient); ok {
+		return rc.MergeRevision(ctx, col, key, data, expected)
+	}
+
+	merged, err := s.driver.Merge(ctx, col, key, data)
+	return merged, "", err
+}
+
+func (s *storeProc) delete(ctx context.Context, col string, key string, expected string) error {
+	if rc, ok := s.driver.(RevisionClient); ok {
+		return rc.DeleteRevision(ctx, col, key, expected)
+	}
+
+	return s.driver.Delete(ctx, col, key)
+}
+
+func setDocumentMeta(message *service.Message, key string, found bool) {
+	message.MetaSetMut("storage_key", key)
+	message.MetaSetMut("storage_found", found)
+}
+
+func setRevisionMeta(message *service.Message, rev string) {
+	if rev != "" {
+		message.MetaSetMut("storage_revision", rev)
+	}
+}
+
+func (s *storeProc) pagingOpts(message *service.Message) (*PagingOpts, error) {
+	result := &PagingOpts{}
+
+	for field, v := range map[string]struct {
+		value  *service.InterpolatedString
+		target *int64
+	}{
+		"offset":    {s.offset, &result.Offset},
+		"limit":     {s.limit, &result.Size},
+		"page_size": {s.pageSize, &result.PageSize},
+	} {
+		if v.value == nil {
+			continue
+		}
+
+		str, err := v.value.TryString(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", field, err)
+		}
+
+		if str == "" {
+			continue
+		}
+
+		if *v.target, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", field, str, err)
+		}
+
+		if *v.target < 0 {
+			return nil, fmt.Errorf("%s must not be negative", field)
+		}
+	}
+
+	if s.sort != nil {
+		str, err := s.sort.TryString(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sort: %w", err)
+		}
+
+		if result.Sort, err = ParseSort(str); err != nil {
+			return nil, err
+		}
+	}
+
+	keepAlive, err := s.pitKeepAlive.TryString(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pit keep alive: %w", err)
+	}
+	result.KeepAlive = keepAlive
+
+	return result, nil
+}
+
+func (s *storeProc) excludesTombstones() bool {
+	return s.tombstones != nil && !s.tombstones.includeDeleted
+}
+
+// listQuery returns the query to list the documents with, being either the compiled filter or the native query.
+func (s *storeProc) listQuery(message *service.Message) (any, error) {
+	if s.filter != nil {
+		flt, err := filterQuery(s.filter, message)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.excludesTombstones() {
+			flt = s.tombstones.exclude(flt)
+		}
+		return flt, nil
+	}
+
+	if s.q == nil && s.excludesTombstones() {
+		return s.tombstones.exclude(nil), nil
+	}
+
+	var q string
+	if s.q != nil {
+		var err error
+		if q, err = s.q.TryString(message); err != nil {
+			return nil, fmt.Errorf("failed to parse query: %w", err)
+		}
+	}
+
+	switch {
+	case s.pit:
+		qry, err := s.driver.ParseQuery(q)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse query: %w", err)
+		}
+		return qry, nil
+	case s.argsMapping != nil:
+		params, err := s.queryParams(message)
+		if err != nil {
+			return nil, err
+		}
+		return ParameterizedQuery{Query: q, Params: params}, nil
+	default:
+		return q, nil
+	}
+}
+
+// filterQuery executes the filter mapping against the message, parsing the resulting filter document.
+func filterQuery(mapping *bloblang.Executor, message *service.Message) (*Filter, error) {
+	res, err := message.BloblangQuery(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute filter: %w", err)
+	}
+
+	f, err := res.AsStructured()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filter result: %w", err)
+	}
+
+	doc, ok := f.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("filter must result in an object, got %T", f)
+	}
+
+	flt, err := ParseFilterDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	return flt, nil
+}
+
+func (s *storeProc) queryParams(message *service.Message) (map[string]any, error) {
+	res, err := message.BloblangQuery(s.argsMapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute args mapping: %w", err)
+	}
+
+	p, err := res.AsStructured()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get args mapping result: %w", err)
+	}
+
+	params, ok := p.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("args mapping must result in an object, got %T", p)
+	}
+
+	return params, nil
+}
+
+// messagePayload returns the document held by the message.
+func messagePayload(message *service.Message) (map[string]any, error) {
 	//sd, err := s.value.Query(message)
 	sd, err := message.AsStructuredMut()
 	if err != nil {
@@ -367,6 +1501,151 @@ func (s *storeProc) getMessagePayload(message *service.Message) (map[string]any,
 	}
 }
 
+func expiryFromConfig(conf *service.ParsedConfig, cl Client, operation string, mgr *service.Resources) (*service.InterpolatedString, *expirer, error) {
+	switch operation {
+	case "set", "add", "merge":
+	default:
+		return nil, nil, fmt.Errorf("a ttl can not be applied to operation %q", operation)
+	}
+
+	if !SupportsExpiry(cl) {
+		return nil, nil, fmt.Errorf("the driver does not support expiring documents")
+	}
+
+	ttl, err := conf.FieldInterpolatedString("ttl")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ttl: %w", err)
+	}
+
+	field, err := conf.FieldString("ttl_field")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ttl field: %w", err)
+	}
+
+	if field == "" {
+		return nil, nil, fmt.Errorf("ttl field must not be empty")
+	}
+
+	str, err := conf.FieldString("ttl_purge_interval")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ttl purge interval: %w", err)
+	}
+
+	interval, err := time.ParseDuration(str)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ttl purge interval %q: %w", str, err)
+	}
+
+	if interval <= 0 {
+		return nil, nil, fmt.Errorf("ttl purge interval must be positive")
+	}
+
+	return ttl, newExpirer(cl, strings.Split(field, "."), interval, mgr.Logger()), nil
+}
+
+// graphFromConfig sets up the vertices and traversal options of the graph operations.
+func (s *storeProc) graphFromConfig(conf *service.ParsedConfig) (err error) {
+	if _, ok := s.driver.(GraphClient); !ok {
+		return fmt.Errorf("the driver does not support operation %q", s.operation)
+	}
+
+	if s.from, err = vertexExprFromConfig(conf, "from"); err != nil {
+		return err
+	}
+
+	if s.operation != "traverse" {
+		if s.to, err = vertexExprFromConfig(conf, "to"); err != nil {
+			return err
+		}
+	}
+
+	// -- edges can only be stored in edge collections
+	s.collectionSpec.Edges = true
+
+	if s.traversal.Direction, err = conf.FieldString("direction"); err != nil {
+		return fmt.Errorf("failed to get direction: %w", err)
+	}
+
+	if s.traversal.MinDepth, err = conf.FieldInt("min_depth"); err != nil {
+		return fmt.Errorf("failed to get min depth: %w", err)
+	}
+
+	if s.traversal.MaxDepth, err = conf.FieldInt("max_depth"); err != nil {
+		return fmt.Errorf("failed to get max depth: %w", err)
+	}
+
+	if s.traversal.MinDepth < 0 || s.traversal.MaxDepth < 1 || s.traversal.MinDepth > s.traversal.MaxDepth {
+		return fmt.Errorf("invalid depth range %d..%d", s.traversal.MinDepth, s.traversal.MaxDepth)
+	}
+
+	return nil
+}
+
+func collectionSpecFromConfig(conf *service.ParsedConfig) (CollectionSpec, error) {
+	var result CollectionSpec
+	var err error
+
+	if conf.Contains("mappings") {
+		if result.Mappings, err = anyMap(conf, "mappings"); err != nil {
+			return result, err
+		}
+	}
+
+	if conf.Contains("settings") {
+		if result.Settings, err = anyMap(conf, "settings"); err != nil {
+			return result, err
+		}
+	}
+
+	indexes, err := conf.FieldObjectList("indexes")
+	if err != nil {
+		return result, fmt.Errorf("failed to get indexes: %w", err)
+	}
+
+	for _, ic := range indexes {
+		var idx IndexSpec
+		if idx.Name, err = ic.FieldString("name"); err != nil {
+			return result, fmt.Errorf("failed to get index name: %w", err)
+		}
+		if idx.Fields, err = ic.FieldStringList("fields"); err != nil {
+			return result, fmt.Errorf("failed to get index fields: %w", err)
+		}
+		if len(idx.Fields) == 0 {
+			return result, fmt.Errorf("an index requires at least one field")
+		}
+		if idx.Unique, err = ic.FieldBool("unique"); err != nil {
+			return result, fmt.Errorf("failed to get index unique flag: %w", err)
+		}
+		if idx.Sparse, err = ic.FieldBool("sparse"); err != nil {
+			return result, fmt.Errorf("failed to get index sparse flag: %w", err)
+		}
+
+		result.Indexes = append(result.Indexes, idx)
+	}
+
+	return result, nil
+}
+
+func anyMap(conf *service.ParsedConfig, field string) (map[string]any, error) {
+	fields, err := conf.FieldAnyMap(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", field, err)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	result := map[string]any{}
+	for k, v := range fields {
+		if result[k], err = v.FieldAny(); err != nil {
+			return nil, fmt.Errorf("failed to get %s.%s: %w", field, k, err)
+		}
+	}
+
+	return result, nil
+}
+
 func CopyMeta(src, dst *service.Message) {
 	_ = src.MetaWalk(func(k string, v string) error {
 		dst.MetaSetMut(k, v)
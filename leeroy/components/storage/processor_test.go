@@ -0,0 +1,941 @@
+package storage
+
+import (
+	"context"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/benthosdev/benthos/v4/public/components/pure"
+)
+
+func TestProcInit(t *testing.T) {
+	fnd := false
+	service.GlobalEnvironment().WalkProcessors(func(name string, config *service.ConfigView) {
+		if name == "storage" {
+			fnd = true
+		}
+	})
+
+	assert.True(t, fnd)
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("should set and get a document", shouldSetAndGet)
+	t.Run("should fail to add an existing document", shouldFailToAddExisting)
+	t.Run("should merge into a document", shouldMerge)
+	t.Run("should delete a document", shouldDelete)
+	t.Run("should handle missing documents", shouldHandleMissing)
+	t.Run("should map results into the original message", shouldMapResults)
+	t.Run("should soft delete documents", shouldSoftDelete)
+	t.Run("should expire documents", shouldExpire)
+	t.Run("should list matching documents", shouldList)
+	t.Run("should list documents matching a filter", shouldListFiltered)
+	t.Run("should list a sorted page of documents", shouldListSortedPage)
+	t.Run("should count and aggregate documents", shouldCountAndAggregate)
+	t.Run("should list documents as separate messages", shouldListDocuments)
+	t.Run("should list documents in pages", shouldListPages)
+	t.Run("should reject writes on a stale revision", shouldRejectStaleRevision)
+	t.Run("should add documents in bulk", shouldAddInBulk)
+	t.Run("should flag failed messages when not in bulk", shouldFlagFailedMessages)
+	t.Run("should reject multiple drivers", shouldRejectMultipleDrivers)
+	t.Run("should reject args mapping with point in time", shouldRejectArgsMappingWithPit)
+	t.Run("should release the driver of an invalid configuration", shouldReleaseDriverOfInvalidConfig)
+	t.Run("should reject graph operations on unsupported drivers", shouldRejectUnsupportedGraphOperations)
+	t.Run("should ensure collections once", shouldEnsureCollectionsOnce)
+	t.Run("should ensure collections independently", shouldEnsureCollectionsIndependently)
+}
+
+func newTestProc(t *testing.T, yaml string) *storeProc {
+	t.Helper()
+
+	conf, err := storeProcConfig().ParseYAML(strings.TrimSpace(yaml), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	prc, err := procFromConfig(conf, service.MockResources())
+	require.NoError(t, err)
+
+	return prc
+}
+
+func newTestMessage(meta map[string]string, payload any) *service.Message {
+	msg := service.NewMessage(nil)
+	msg.SetStructuredMut(payload)
+	for k, v := range meta {
+		msg.MetaSetMut(k, v)
+	}
+
+	return msg
+}
+
+func shouldSetAndGet(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	setter := newTestProc(t, `
+driver:
+  memory:
+    name: set_and_get
+collection: concepts
+operation: set
+key: ${! meta("key") }
+`)
+
+	getter := newTestProc(t, `
+driver:
+  memory:
+    name: set_and_get
+collection: concepts
+operation: get
+key: ${! meta("key") }
+`)
+
+	res, err := setter.Process(tCtx, newTestMessage(map[string]string{"key": "a"}, map[string]any{"name": "alpha"}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	res, err = getter.Process(tCtx, newTestMessage(map[string]string{"key": "a"}, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "alpha"}, doc)
+
+	key, fnd := res[0].MetaGet("key")
+	assert.True(t, fnd)
+	assert.Equal(t, "a", key)
+}
+
+func shouldFailToAddExisting(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: add_existing
+collection: concepts
+operation: add
+key: a
+`)
+
+	_, err := prc.Process(tCtx, newTestMessage(nil, map[string]any{"name": "alpha"}))
+	require.NoError(t, err)
+
+	_, err = prc.Process(tCtx, newTestMessage(nil, map[string]any{"name": "alpha"}))
+	assert.ErrorIs(t, err, service.ErrKeyAlreadyExists)
+}
+
+func shouldMerge(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: merge
+collection: concepts
+operation: merge
+key: a
+`)
+
+	_, err := prc.Process(tCtx, newTestMessage(nil, map[string]any{
+		"name":    "alpha",
+		"address": map[string]any{"city": "Ghent"},
+	}))
+	require.NoError(t, err)
+
+	res, err := prc.Process(tCtx, newTestMessage(nil, map[string]any{
+		"address": map[string]any{"street": "Veldstraat"},
+	}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"name":    "alpha",
+		"address": map[string]any{"city": "Ghent", "street": "Veldstraat"},
+	}, doc)
+}
+
+func shouldDelete(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("delete")
+	require.NoError(t, cl.Set(tCtx, "concepts", "a", map[string]any{"name": "alpha"}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: delete
+collection: concepts
+operation: delete
+key: a
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "alpha"}, doc)
+
+	_, err = cl.Get(tCtx, "concepts", "a")
+	assert.ErrorIs(t, err, service.ErrKeyNotFound)
+}
+
+func shouldHandleMissing(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	for _, op := range []string{"get", "delete"} {
+		prc := newTestProc(t, `
+driver:
+  memory:
+    name: missing
+collection: concepts
+operation: `+op+`
+key: a
+`)
+		_, err := prc.Process(tCtx, newTestMessage(nil, nil))
+		assert.ErrorIs(t, err, ErrNotFound, op)
+		assert.ErrorIs(t, err, service.ErrKeyNotFound, op)
+
+		skipper := newTestProc(t, `
+driver:
+  memory:
+    name: missing
+collection: concepts
+operation: `+op+`
+key: a
+on_missing: skip
+`)
+		res, err := skipper.Process(tCtx, newTestMessage(nil, map[string]any{"name": "original"}))
+		require.NoError(t, err, op)
+		require.Len(t, res, 1, op)
+
+		doc, err := res[0].AsStructured()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"name": "original"}, doc, op)
+
+		emptier := newTestProc(t, `
+driver:
+  memory:
+    name: missing
+collection: concepts
+operation: `+op+`
+key: a
+on_missing: empty
+`)
+		res, err = emptier.Process(tCtx, newTestMessage(map[string]string{"source": "test"}, map[string]any{"name": "original"}))
+		require.NoError(t, err, op)
+		require.Len(t, res, 1, op)
+
+		doc, err = res[0].AsStructured()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{}, doc, op)
+
+		src, _ := res[0].MetaGet("source")
+		assert.Equal(t, "test", src, op)
+	}
+}
+
+func shouldMapResults(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("result_map")
+	require.NoError(t, cl.Set(tCtx, "customers", "c1", map[string]any{"name": "alpha"}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: result_map
+collection: customers
+operation: get
+key: ${! json("customer_id") }
+on_missing: empty
+result_map: 'root.customer = this'
+`)
+
+	original := newTestMessage(map[string]string{"source": "test"}, map[string]any{"order": "o1", "customer_id": "c1"})
+	res, err := prc.Process(tCtx, original)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"order": "o1", "customer_id": "c1", "customer": map[string]any{"name": "alpha"}}, doc)
+
+	for k, v := range map[string]any{
+		"source":             "test",
+		"storage_operation":  "get",
+		"storage_collection": "customers",
+		"storage_key":        "c1",
+		"storage_found":      true,
+		"storage_revision":   "1",
+	} {
+		mv, fnd := res[0].MetaGetMut(k)
+		assert.True(t, fnd, k)
+		assert.Equal(t, v, mv, k)
+	}
+
+	// -- the original message is left untouched
+	doc, err = original.AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"order": "o1", "customer_id": "c1"}, doc)
+
+	res, err = prc.Process(tCtx, newTestMessage(nil, map[string]any{"order": "o2", "customer_id": "c2"}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err = res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"order": "o2", "customer_id": "c2", "customer": map[string]any{}}, doc)
+
+	found, _ := res[0].MetaGetMut("storage_found")
+	assert.Equal(t, false, found)
+}
+
+func shouldSoftDelete(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("soft_delete")
+	require.NoError(t, cl.Set(tCtx, "concepts", "a", map[string]any{"name": "alpha"}))
+	require.NoError(t, cl.Set(tCtx, "concepts", "b", map[string]any{"name": "beta"}))
+
+	newProc := func(operation string, extra string) *storeProc {
+		return newTestProc(t, `
+driver:
+  memory:
+    name: soft_delete
+collection: concepts
+operation: `+operation+`
+key: a
+soft_delete:
+  enabled: true
+  event: ${! meta("event_id") }
+`+extra)
+	}
+
+	res, err := newProc("delete", "").Process(tCtx, newTestMessage(map[string]string{"event_id": "e1"}, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	deleted, _ := res[0].MetaGetMut("storage_deleted")
+	assert.Equal(t, true, deleted)
+
+	// -- the document is kept as a tombstone
+	doc, err := cl.Get(tCtx, "concepts", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "alpha", doc["name"])
+	assert.Equal(t, true, doc["_deleted"])
+	assert.Equal(t, "e1", doc["_deleted_by"])
+	assert.NotEmpty(t, doc["_deleted_at"])
+
+	_, err = newProc("get", "").Process(tCtx, newTestMessage(nil, nil))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = newProc("delete", "").Process(tCtx, newTestMessage(nil, nil))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	res, err = newProc("get", "  include_deleted: true\n").Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	deleted, _ = res[0].MetaGetMut("storage_deleted")
+	assert.Equal(t, true, deleted)
+
+	for _, extra := range []string{"", "q: 'name != \"gamma\"'\n"} {
+		res, err = newProc("list", extra).Process(tCtx, newTestMessage(nil, nil))
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+
+		docs, err := res[0].AsStructured()
+		require.NoError(t, err)
+		assert.Equal(t, []any{map[string]any{"name": "beta"}}, docs, extra)
+	}
+}
+
+func shouldExpire(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	setter := newTestProc(t, `
+driver:
+  memory:
+    name: expire
+collection: sessions
+operation: set
+key: ${! meta("key") }
+ttl: ${! meta("ttl").or("") }
+`)
+	defer setter.Close(tCtx)
+
+	getter := newTestProc(t, `
+driver:
+  memory:
+    name: expire
+collection: sessions
+operation: get
+key: ${! meta("key") }
+on_missing: skip
+`)
+
+	before := time.Now()
+	res, err := setter.Process(tCtx, newTestMessage(map[string]string{"key": "a", "ttl": "1h"}, map[string]any{"user": "alpha"}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	_, err = setter.Process(tCtx, newTestMessage(map[string]string{"key": "b"}, map[string]any{"user": "beta"}))
+	require.NoError(t, err)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	expiresAt, err := time.Parse(time.RFC3339, doc.(map[string]any)["_expires_at"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Hour), expiresAt, 2*time.Second)
+
+	// -- nothing has expired yet
+	setter.expirer.purge(time.Now())
+	res, err = getter.Process(tCtx, newTestMessage(map[string]string{"key": "a"}, map[string]any{}))
+	require.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	setter.expirer.purge(time.Now().Add(2 * time.Hour))
+	res, err = getter.Process(tCtx, newTestMessage(map[string]string{"key": "a"}, map[string]any{}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	found, _ := res[0].MetaGetMut("storage_found")
+	assert.Equal(t, false, found)
+
+	// -- documents without a ttl are kept
+	res, err = getter.Process(tCtx, newTestMessage(map[string]string{"key": "b"}, map[string]any{}))
+	require.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	_, err = setter.Process(tCtx, newTestMessage(map[string]string{"key": "c", "ttl": "soon"}, map[string]any{}))
+	assert.Error(t, err)
+}
+
+func shouldList(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("list")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"customer": "a", "total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"customer": "a", "total": 15}))
+	require.NoError(t, cl.Set(tCtx, "orders", "3", map[string]any{"customer": "b", "total": 25}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: list
+collection: orders
+operation: list
+q: 'customer == "${! meta("customer") }" AND total >= 10'
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(map[string]string{"customer": "a"}, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	docs, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"customer": "a", "total": 15},
+	}, docs)
+}
+
+func shouldListFiltered(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("list_filtered")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"customer": "a", "total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"customer": "a", "total": 15, "priority": "high"}))
+	require.NoError(t, cl.Set(tCtx, "orders", "3", map[string]any{"customer": "b", "total": 25}))
+	require.NoError(t, cl.Set(tCtx, "orders", "4", map[string]any{"customer": "c", "total": 35}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: list_filtered
+collection: orders
+operation: list
+filter: |
+  root.customer = {"$in": this.customers}
+  root."$or" = [{"priority": "high"}, {"total": {"$gt": this.min_total}}]
+sort: total:desc
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(nil, map[string]any{"customers": []any{"a", "b"}, "min_total": 20}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	docs, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"customer": "b", "total": 25},
+		map[string]any{"customer": "a", "total": 15, "priority": "high"},
+	}, docs)
+}
+
+func shouldCountAndAggregate(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("aggregate")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"customer": "a", "total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"customer": "a", "total": 15}))
+	require.NoError(t, cl.Set(tCtx, "orders", "3", map[string]any{"customer": "b", "total": 25}))
+
+	counter := newTestProc(t, `
+driver:
+  memory:
+    name: aggregate
+collection: orders
+operation: count
+filter: 'root.customer = this.customer'
+`)
+
+	res, err := counter.Process(tCtx, newTestMessage(nil, map[string]any{"customer": "a"}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	doc, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"count": int64(2)}, doc)
+
+	aggregator := newTestProc(t, `
+driver:
+  memory:
+    name: aggregate
+collection: orders
+operation: aggregate
+group_by: [ customer ]
+metrics:
+  orders: count()
+  revenue: sum(total)
+`)
+
+	res, err = aggregator.Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	docs, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"customer": "a", "orders": int64(2), "revenue": float64(20)},
+		map[string]any{"customer": "b", "orders": int64(1), "revenue": float64(25)},
+	}, docs)
+}
+
+func shouldListSortedPage(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("list_sorted")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"total": 15}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "3", map[string]any{"total": 25}))
+	require.NoError(t, cl.Set(tCtx, "orders", "4", map[string]any{"total": 10}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: list_sorted
+collection: orders
+operation: list
+q: ""
+sort: total:desc
+offset: ${! meta("offset") }
+limit: 2
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(map[string]string{"offset": "1"}, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	docs, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"total": 15},
+		map[string]any{"total": 10},
+	}, docs)
+}
+
+func shouldListDocuments(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("list_documents")
+	require.NoError(t, cl.Set(tCtx, "orders", "1", map[string]any{"total": 5}))
+	require.NoError(t, cl.Set(tCtx, "orders", "2", map[string]any{"total": 15}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: list_documents
+collection: orders
+operation: list
+q: ""
+list_output: documents
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+
+	for i, total := range []any{5, 15} {
+		doc, err := res[i].AsStructured()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"total": total}, doc)
+
+		col, fnd := res[i].MetaGetMut("storage_collection")
+		assert.True(t, fnd)
+		assert.Equal(t, "orders", col)
+
+		pos, fnd := res[i].MetaGetMut("storage_position")
+		assert.True(t, fnd)
+		assert.Equal(t, i, pos)
+	}
+}
+
+func shouldListPages(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("list_pages")
+	for _, k := range []string{"1", "2", "3"} {
+		require.NoError(t, cl.Set(tCtx, "orders", k, map[string]any{"id": k}))
+	}
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: list_pages
+collection: orders
+operation: list
+q: ""
+list_output: pages
+list_page_size: 2
+`)
+
+	res, err := prc.Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+
+	first, err := res[0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{map[string]any{"id": "1"}, map[string]any{"id": "2"}}, first)
+
+	second, err := res[1].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, []any{map[string]any{"id": "3"}}, second)
+
+	pos, fnd := res[1].MetaGetMut("storage_position")
+	assert.True(t, fnd)
+	assert.Equal(t, 2, pos)
+}
+
+func shouldRejectStaleRevision(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("revision")
+	require.NoError(t, cl.Set(tCtx, "concepts", "a", map[string]any{"name": "alpha"}))
+
+	getter := newTestProc(t, `
+driver:
+  memory:
+    name: revision
+collection: concepts
+operation: get
+key: a
+`)
+
+	merger := newTestProc(t, `
+driver:
+  memory:
+    name: revision
+collection: concepts
+operation: merge
+key: a
+revision: ${! meta("storage_revision") }
+`)
+
+	res, err := getter.Process(tCtx, newTestMessage(nil, nil))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	rev, fnd := res[0].MetaGet("storage_revision")
+	require.True(t, fnd)
+
+	// -- the first merge moves the document to a new revision
+	res, err = merger.Process(tCtx, newTestMessage(map[string]string{"storage_revision": rev}, map[string]any{"name": "beta"}))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	newRev, fnd := res[0].MetaGet("storage_revision")
+	require.True(t, fnd)
+	assert.NotEqual(t, rev, newRev)
+
+	// -- so a second merge on the original revision fails
+	_, err = merger.Process(tCtx, newTestMessage(map[string]string{"storage_revision": rev}, map[string]any{"name": "gamma"}))
+	assert.ErrorIs(t, err, ErrRevisionMismatch)
+
+	doc, err := cl.Get(tCtx, "concepts", "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "beta"}, doc)
+}
+
+func shouldAddInBulk(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("bulk")
+	require.NoError(t, cl.Set(tCtx, "concepts", "b", map[string]any{"name": "existing"}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: bulk
+collection: concepts
+operation: add
+key: ${! json("name") }
+bulk: true
+`)
+
+	res, err := prc.ProcessBatch(tCtx, service.MessageBatch{
+		newTestMessage(nil, map[string]any{"name": "a"}),
+		newTestMessage(nil, map[string]any{"name": "b"}),
+		newTestMessage(nil, map[string]any{"name": "c"}),
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Len(t, res[0], 3)
+
+	assert.NoError(t, res[0][0].GetError())
+	assert.ErrorIs(t, res[0][1].GetError(), service.ErrKeyAlreadyExists)
+	assert.NoError(t, res[0][2].GetError())
+
+	doc, err := cl.Get(tCtx, "concepts", "c")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "c"}, doc)
+
+	doc, err = cl.Get(tCtx, "concepts", "b")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "existing"}, doc)
+}
+
+func shouldFlagFailedMessages(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	cl := NewMemoryClient("batch")
+	require.NoError(t, cl.Set(tCtx, "concepts", "a", map[string]any{"name": "alpha"}))
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: batch
+collection: concepts
+operation: get
+key: ${! json("key") }
+`)
+
+	res, err := prc.ProcessBatch(tCtx, service.MessageBatch{
+		newTestMessage(nil, map[string]any{"key": "a"}),
+		newTestMessage(nil, map[string]any{"key": "b"}),
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Len(t, res[0], 2)
+
+	doc, err := res[0][0].AsStructured()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"name": "alpha"}, doc)
+
+	assert.ErrorIs(t, res[0][1].GetError(), service.ErrKeyNotFound)
+}
+
+func shouldRejectMultipleDrivers(t *testing.T) {
+	conf, err := storeProcConfig().ParseYAML(strings.TrimSpace(`
+driver:
+  memory: {}
+  elasticsearch:
+    addresses: [ "http://localhost:9200" ]
+collection: concepts
+operation: get
+key: a
+`), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	_, err = procFromConfig(conf, service.MockResources())
+	assert.Error(t, err)
+}
+
+func shouldRejectArgsMappingWithPit(t *testing.T) {
+	conf, err := storeProcConfig().ParseYAML(strings.TrimSpace(`
+driver:
+  memory: {}
+collection: orders
+operation: list
+enable_pit: true
+q: d.status == @status
+args_mapping: root.status = this.status
+`), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	_, err = procFromConfig(conf, service.MockResources())
+	assert.ErrorContains(t, err, "enable_pit")
+}
+
+func shouldReleaseDriverOfInvalidConfig(t *testing.T) {
+	config := func(address string) string {
+		return `
+driver:
+  elasticsearch:
+    connection: released
+    addresses: [ "` + address + `" ]
+collection: concepts
+operation: get
+key: a
+bulk: true
+`
+	}
+
+	conf, err := storeProcConfig().ParseYAML(strings.TrimSpace(config("http://first:9200")), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	_, err = procFromConfig(conf, service.MockResources())
+	require.ErrorContains(t, err, "in bulk")
+
+	// -- the named connection is gone along with the rejected processor, so it can be configured again
+	conf, err = storeProcConfig().ParseYAML(strings.TrimSpace(config("http://second:9200")), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	_, err = procFromConfig(conf, service.MockResources())
+	assert.NotContains(t, err.Error(), "different settings")
+}
+
+func shouldRejectUnsupportedGraphOperations(t *testing.T) {
+	conf, err := storeProcConfig().ParseYAML(strings.TrimSpace(`
+driver:
+  memory: {}
+collection: relations
+operation: link
+from:
+  concept: CON#crm#customer
+  key: ${! json("customer") }
+to:
+  concept: CON#crm#order
+  key: ${! json("order") }
+`), service.GlobalEnvironment())
+	require.NoError(t, err)
+
+	_, err = procFromConfig(conf, service.MockResources())
+	assert.Error(t, err)
+}
+
+type ensuringClient struct {
+	*MemoryClient
+	ensured []string
+	spec    CollectionSpec
+}
+
+func (c *ensuringClient) EnsureCollection(ctx context.Context, collection string, spec CollectionSpec) error {
+	c.ensured = append(c.ensured, collection)
+	c.spec = spec
+	return nil
+}
+
+func shouldEnsureCollectionsOnce(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: ensure
+collection: ${! meta("collection") }
+operation: set
+key: a
+ensure_collection:
+  enabled: true
+  mappings:
+    properties:
+      name: { type: keyword }
+  indexes:
+    - fields: [ name ]
+      unique: true
+`)
+
+	cl := &ensuringClient{MemoryClient: NewMemoryClient("ensure")}
+	prc.driver = cl
+
+	for _, col := range []string{"concepts", "concepts", "events"} {
+		_, err := prc.Process(tCtx, newTestMessage(map[string]string{"collection": col}, map[string]any{"name": "alpha"}))
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"concepts", "events"}, cl.ensured)
+	assert.Equal(t, CollectionSpec{
+		Mappings: map[string]any{"properties": map[string]any{"name": map[string]any{"type": "keyword"}}},
+		Indexes:  []IndexSpec{{Fields: []string{"name"}, Unique: true}},
+	}, cl.spec)
+}
+
+type blockingEnsureClient struct {
+	*MemoryClient
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingEnsureClient) EnsureCollection(ctx context.Context, collection string, spec CollectionSpec) error {
+	if collection == "slow" {
+		close(c.entered)
+		<-c.release
+	}
+	return nil
+}
+
+func shouldEnsureCollectionsIndependently(t *testing.T) {
+	tCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+
+	prc := newTestProc(t, `
+driver:
+  memory:
+    name: ensure_independently
+collection: ${! meta("collection") }
+operation: set
+key: a
+ensure_collection:
+  enabled: true
+`)
+
+	cl := &blockingEnsureClient{MemoryClient: NewMemoryClient("ensure_independently"), entered: make(chan struct{}), release: make(chan struct{})}
+	prc.driver = cl
+
+	slow := make(chan error, 1)
+	go func() {
+		slow <- prc.ensureCollection(tCtx, "slow")
+	}()
+	<-cl.entered
+
+	// -- other collections are ensured while the slow one is still being created
+	require.NoError(t, prc.ensureCollection(tCtx, "fast"))
+
+	close(cl.release)
+	require.NoError(t, <-slow)
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"sort"
+	"sync"
+)
+
+// DriverConstructor creates a Client from the parsed configuration of a driver.
+type DriverConstructor func(conf *service.ParsedConfig, mgr *service.Resources) (Client, error)
+
+// Driver describes a storage backend that can be selected within the `driver` object of the storage components.
+type Driver struct {
+	Name        string
+	Fields      []*service.ConfigField
+	Constructor DriverConstructor
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+// RegisterDriver makes a storage driver available under the given name. The fields are exposed as the configuration
+// of the driver and the constructor is invoked with those fields when a component selects the driver.
+//
+// Drivers registered from outside of this package become available as soon as they are registered; the storage
+// components are re-registered with benthos to include the new driver in their config spec.
+func RegisterDriver(name string, fields []*service.ConfigField, ctor DriverConstructor) error {
+	if name == "" {
+		return fmt.Errorf("a driver name is required")
+	}
+
+	if ctor == nil {
+		return fmt.Errorf("driver %q has no constructor", name)
+	}
+
+	driversMu.Lock()
+	if _, fnd := drivers[name]; fnd {
+		driversMu.Unlock()
+		return fmt.Errorf("driver %q is already registered", name)
+	}
+	drivers[name] = Driver{Name: name, Fields: fields, Constructor: ctor}
+	driversMu.Unlock()
+
+	return registerComponents()
+}
+
+// Drivers returns the registered drivers ordered by name.
+func Drivers() []Driver {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	result := make([]Driver, 0, len(drivers))
+	for _, d := range drivers {
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// DriverField returns the `driver` object field holding one optional object field per registered driver.
+func DriverField() *service.ConfigField {
+	var fields []*service.ConfigField
+	for _, d := range Drivers() {
+		fields = append(fields, service.NewObjectField(d.Name, d.Fields...).Default(nil))
+	}
+
+	return service.NewObjectField("driver", fields...).
+		Description("The driver to use for accessing the store. Exactly one of the registered drivers must be configured.")
+}
+
+// NewClientFromConfig creates a client for the single driver configured within the given `driver` namespace.
+func NewClientFromConfig(conf *service.ParsedConfig, mgr *service.Resources) (Client, error) {
+	var configured []Driver
+	for _, d := range Drivers() {
+		// -- unconfigured drivers default to nil
+		if v, err := conf.FieldAny(d.Name); err == nil && v != nil {
+			configured = append(configured, d)
+		}
+	}
+
+	switch len(configured) {
+	case 0:
+		return nil, fmt.Errorf("no driver specified")
+	case 1:
+		d := configured[0]
+		cl, err := d.Constructor(conf.Namespace(d.Name), mgr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s driver: %w", d.Name, err)
+		}
+		return cl, nil
+	default:
+		return nil, fmt.Errorf("only one driver can be specified, got %d", len(configured))
+	}
+}
+
+// registerComponents (re)registers the storage components so their config specs reflect the registered drivers.
+func registerComponents() error {
+	err := service.RegisterBatchProcessor("storage", storeProcConfig(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+		return procFromConfig(conf, mgr)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = service.RegisterBatchOutput("storage", storeOutputConfig(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchOutput, service.BatchPolicy, int, error) {
+		return outputFromConfig(conf, mgr)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = service.RegisterBatchInput("storage_list", listInputConfig(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+		in, err := listInputFromConfig(conf, mgr)
+		if err != nil {
+			return nil, err
+		}
+		return service.AutoRetryNacksBatched(in), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return service.RegisterBatchInput("storage_changes", changesInputConfig(), func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+		in, err := changesInputFromConfig(conf, mgr)
+		if err != nil {
+			return nil, err
+		}
+		return service.AutoRetryNacksBatched(in), nil
+	})
+}
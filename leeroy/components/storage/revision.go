@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrRevisionMismatch is returned when a document is no longer at the revision a write expected it to be.
+var ErrRevisionMismatch = errors.New("revision mismatch")
+
+// RevisionClient is implemented by clients supporting optimistic concurrency control. Revisions are opaque strings
+// identifying the state of a document. An empty expected revision applies the write unconditionally.
+type RevisionClient interface {
+	GetRevision(ctx context.Context, collection string, key string) (map[string]any, string, error)
+	SetRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (string, error)
+	MergeRevision(ctx context.Context, collection string, key string, value map[string]any, expected string) (map[string]any, string, error)
+	DeleteRevision(ctx context.Context, collection string, key string, expected string) error
+}
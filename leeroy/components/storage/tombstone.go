@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/benthosdev/benthos/v4/public/service"
+	"strings"
+	"time"
+)
+
+func softDeleteField() *service.ConfigField {
+	return service.NewObjectField("soft_delete",
+		service.NewBoolField("enabled").
+			Description("Mark documents as deleted instead of removing them when deleting.").
+			Default(false),
+		service.NewStringField("deleted_field").
+			Description("The dotted path of the field flagging a document as deleted.").
+			Default("_deleted"),
+		service.NewStringField("deleted_at_field").
+			Description("The dotted path of the field holding the time a document was deleted at.").
+			Default("_deleted_at"),
+		service.NewStringField("event_field").
+			Description("The dotted path of the field holding the reference to the event which deleted the document.").
+			Default("_deleted_by"),
+		service.NewInterpolatedStringField("event").
+			Description("The reference to the event deleting the document. The reference is only stored when set.").
+			Example(`${! meta("event_id") }`).
+			Optional(),
+		service.NewBoolField("include_deleted").
+			Description("Return deleted documents from 'get', 'list', 'count' and 'aggregate'. Deleted documents returned by 'get' carry the `storage_deleted` metadata.").
+			Default(false),
+	).
+		Description("Keep deleted documents around as tombstones, remembering that and when they were deleted. Tombstones are left out of 'list', 'count' and 'aggregate' by adding a condition to the `filter`; results of a native `q` are filtered after reading them instead, which is why 'count' and 'aggregate' require a `filter` to leave them out.").
+		Advanced()
+}
+
+// tombstones marks documents as deleted by flagging them rather than removing them from the store.
+type tombstones struct {
+	deletedPath    []string
+	deletedAtPath  []string
+	eventPath      []string
+	event          *service.InterpolatedString
+	includeDeleted bool
+}
+
+func tombstonesFromConfig(conf *service.ParsedConfig) (*tombstones, error) {
+	enabled, err := conf.FieldBool("enabled")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get soft delete flag: %w", err)
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	result := &tombstones{}
+	for field, target := range map[string]*[]string{
+		"deleted_field":    &result.deletedPath,
+		"deleted_at_field": &result.deletedAtPath,
+		"event_field":      &result.eventPath,
+	} {
+		v, err := conf.FieldString(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", field, err)
+		}
+
+		if v == "" {
+			return nil, fmt.Errorf("%s must not be empty", field)
+		}
+
+		*target = strings.Split(v, ".")
+	}
+
+	if conf.Contains("event") {
+		if result.event, err = conf.FieldInterpolatedString("event"); err != nil {
+			return nil, fmt.Errorf("failed to get event: %w", err)
+		}
+	}
+
+	if result.includeDeleted, err = conf.FieldBool("include_deleted"); err != nil {
+		return nil, fmt.Errorf("failed to get include deleted flag: %w", err)
+	}
+
+	return result, nil
+}
+
+// tombstone returns the fields to merge into a document to mark it as deleted by the message.
+func (t *tombstones) tombstone(message *service.Message, now time.Time) (map[string]any, error) {
+	result := map[string]any{}
+	setPath(result, t.deletedPath, true)
+	setPath(result, t.deletedAtPath, now.UTC().Format(time.RFC3339Nano))
+
+	if t.event != nil {
+		event, err := t.event.TryString(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get event: %w", err)
+		}
+
+		if event != "" {
+			setPath(result, t.eventPath, event)
+		}
+	}
+
+	return result, nil
+}
+
+func (t *tombstones) isTombstone(doc map[string]any) bool {
+	v, _ := lookupPath(doc, t.deletedPath)
+	return v == true
+}
+
+// exclude extends the filter to leave out tombstones.
+func (t *tombstones) exclude(flt *Filter) *Filter {
+	alive := &Filter{Op: FilterNe, Path: t.deletedPath, Value: true}
+	if flt == nil {
+		return alive
+	}
+
+	return &Filter{Op: FilterAnd, Filters: []*Filter{flt, alive}}
+}
+
+// tombstoneCursor skips the tombstones of a cursor.
+type tombstoneCursor struct {
+	Cursor
+	tombstones *tombstones
+	next       map[string]any
+	err        error
+}
+
+func (c *tombstoneCursor) HasNext() bool {
+	for c.next == nil && c.err == nil && c.Cursor.HasNext() {
+		doc, err := c.Cursor.Read()
+		if err != nil {
+			// -- keep the error to surface it on the next read
+			c.err = err
+		} else if !c.tombstones.isTombstone(doc) {
+			c.next = doc
+		}
+	}
+
+	return c.next != nil || c.err != nil
+}
+
+func (c *tombstoneCursor) Read() (map[string]any, error) {
+	if !c.HasNext() {
+		return nil, fmt.Errorf("no more documents")
+	}
+
+	doc, err := c.next, c.err
+	c.next, c.err = nil, nil
+
+	return doc, err
+}
+
+func setPath(doc map[string]any, path []string, value any) {
+	for _, p := range path[:len(path)-1] {
+		child, ok := doc[p].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			doc[p] = child
+		}
+		doc = child
+	}
+
+	doc[path[len(path)-1]] = value
+}